@@ -18,6 +18,12 @@ type Config struct {
 	viperData *viper.Viper
 }
 
+// A named sub config defined in an array, see GetArrayItems
+type ArrayItem struct {
+	Key    string
+	Config *Config
+}
+
 func NewConfigWithFile(path string) *Config {
 	logging.Init("debug", "console")
 	if _, err := os.Stat(path); err != nil {
@@ -100,6 +106,11 @@ func (c *Config) GetIntArray(key string) []int {
 	return c.viperData.GetIntSlice(key)
 }
 
+// GetStringMap of key: value pairs under key. Keys are lower case, as all keys in config.
+func (c *Config) GetStringMap(key string) map[string]string {
+	return c.viperData.GetStringMapString(key)
+}
+
 // For config as below:
 // keys:
 //   key1:
@@ -154,3 +165,55 @@ func (c *Config) GetMapFromArray(key string) map[string]interface{} {
 
 	return result
 }
+
+// For config as below:
+// keys:
+//   - key1:
+//       value: xxxxxx
+//   - key2:
+//
+// Similar to GetMapFromArray, but keeping the order as defined in the array.
+// Config is nil if nothing defined under the key, e.g. key2 above.
+func (c *Config) GetArrayItems(key string) []ArrayItem {
+	data := c.viperData.Get(key)
+	if data == nil {
+		return nil
+	}
+
+	result := []ArrayItem{}
+	values := data.([]interface{})
+	for _, v := range values {
+		switch item := v.(type) {
+		case string:
+			// also accept plain value, e.g. "- key1"
+			result = append(result, ArrayItem{Key: item})
+		case map[interface{}]interface{}:
+			for k, v1 := range item {
+				result = append(result, ArrayItem{Key: k.(string), Config: newConfigWithValue(v1)})
+			}
+		}
+	}
+
+	return result
+}
+
+func newConfigWithValue(value interface{}) *Config {
+	values, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+
+	data := map[string]interface{}{}
+	for k, v := range values {
+		data[k.(string)] = v
+	}
+
+	v := viper.New()
+	if err := v.MergeConfigMap(data); err != nil {
+		logging.Errorw("loading config from map failed", "error", err.Error())
+		return nil
+	}
+	return &Config{
+		viperData: v,
+	}
+}
@@ -172,6 +172,32 @@ data:
 	assert.Equal(s.T(), 123, value2["name"].(int))
 	assert.Equal(s.T(), "abcde", value2["data"].(string))
 }
+
+func (s *configTestSuite) TestConfigArrayItems() {
+	confText := `
+data:
+   - value2:
+        name: 123
+        sub:
+           data: "abcde"
+   - value1:
+   - value3
+`
+	conf := NewConfigWithString(confText)
+
+	items := conf.GetArrayItems("data")
+	assert.Equal(s.T(), 3, len(items))
+	assert.Equal(s.T(), "value2", items[0].Key)
+	assert.Equal(s.T(), "value1", items[1].Key)
+	assert.Equal(s.T(), "value3", items[2].Key)
+	assert.Nil(s.T(), items[1].Config)
+	assert.Nil(s.T(), items[2].Config)
+
+	assert.Equal(s.T(), 123, items[0].Config.GetInt("name"))
+	assert.Equal(s.T(), "abcde", items[0].Config.GetString("sub.data"))
+	assert.Nil(s.T(), conf.GetArrayItems("notexist"))
+}
+
 func TestConfigTestSuite(t *testing.T) {
 	suite.Run(t, new(configTestSuite))
 }
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm"
+)
+
+// CreateBatch inserts values in batches of Database.BatchSize(), and indexes them with the elastic bulk api.
+// values is a slice of the model, e.g. []model.User or []*model.User
+func (d *DAO) CreateBatch(values interface{}) error {
+	return d.CreateBatchContext(context.Background(), values)
+}
+
+// CreateBatchContext is the same as CreateBatch, running sql with ctx
+func (d *DAO) CreateBatchContext(ctx context.Context, values interface{}) (err error) {
+	ctx, span := d.startSpan(ctx, "create_batch")
+	defer d.observe("create_batch", time.Now(), span, &err)
+
+	return d.writeBatch(ctx, d.db.WithContext(ctx), values, nil)
+}
+
+// UpsertBatch is the same as Upsert for a slice of the model, see CreateBatch
+func (d *DAO) UpsertBatch(values interface{}, queryColumns []string, assignedColums []string) error {
+	return d.UpsertBatchContext(context.Background(), values, queryColumns, assignedColums)
+}
+
+// UpsertBatchContext is the same as UpsertBatch, running sql with ctx
+func (d *DAO) UpsertBatchContext(ctx context.Context, values interface{}, queryColumns []string, assignedColums []string) (err error) {
+	ctx, span := d.startSpan(ctx, "upsert_batch")
+	defer d.observe("upsert_batch", time.Now(), span, &err)
+
+	db := d.db.WithContext(ctx)
+	if len(queryColumns) > 0 {
+		db = db.Clauses(d.onConflict(queryColumns, assignedColums))
+	}
+	return d.writeBatch(ctx, db, values, queryColumns)
+}
+
+func (d *DAO) writeBatch(ctx context.Context, db *gorm.DB, values interface{}, queryColumns []string) error {
+	models, err := d.batchModels(values)
+	if err != nil {
+		return logging.Errorf("invalid batch for [%s]: %s", d.model.TableName(), err.Error())
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	for _, model := range models {
+		d.initVersion(model)
+	}
+
+	err = d.atomically(db, func(db *gorm.DB) error {
+		if err := db.CreateInBatches(values, d.db.BatchSize()).Error; err != nil {
+			return err
+		}
+		ids, err := d.upsertedIDs(db, models, queryColumns)
+		if err != nil {
+			return err
+		}
+		return d.enqueue(db, ids...)
+	})
+	if err != nil {
+		return logging.Errorf("batch write failed for [%s]: %s", d.model.TableName(), err.Error())
+	}
+
+	if d.db.outbox == nil && d.es != nil {
+		d.afterWrite(ctx, func(ctx context.Context) {
+			if len(queryColumns) == 0 {
+				d.bulkIndex(ctx, models)
+				return
+			}
+			// updated records keep their uuids in database instead of the ones in values.
+			// They are written already, so failures are only logged the same as indexing.
+			ids, err := d.storedIDs(d.db.primary(d.db.WithContext(detachedContext{ctx})), models, queryColumns)
+			if err != nil {
+				logging.Errorf("failed to find upserted records of [%s]: %s", d.model.TableName(), err.Error())
+				return
+			}
+			d.indexStored(ctx, ids)
+		})
+	}
+	return nil
+}
+
+// items of the slice as DaoModel, pointing to the slice elements so generated values are visible
+func (d *DAO) batchModels(values interface{}) ([]DaoModel, error) {
+	v := reflect.Indirect(reflect.ValueOf(values))
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("values should be a slice, got %T", values)
+	}
+
+	models := make([]DaoModel, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i)
+		if item.Kind() != reflect.Ptr {
+			item = item.Addr()
+		}
+		model, ok := item.Interface().(DaoModel)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a DaoModel", item.Type())
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
+
+// index the records of the uuids as they are stored in database
+func (d *DAO) indexStored(ctx context.Context, ids []string) {
+	if d.es == nil {
+		return
+	}
+
+	if err := d.ensureIndex(detachedContext{ctx}); err != nil {
+		logging.Errorf("bulk index failed: %s", err.Error())
+		return
+	}
+	if err := d.syncRecords(detachedContext{ctx}, d.esIndexName(), ids); err != nil {
+		logging.Errorf("bulk index failed: %s", err.Error())
+	}
+}
+
+// index the models in _bulk requests of Database.BatchSize() documents
+func (d *DAO) bulkIndex(ctx context.Context, models []DaoModel) {
+	if d.es == nil {
+		return
+	}
+
+	if err := d.ensureIndex(detachedContext{ctx}); err != nil {
+		logging.Errorf("bulk index failed: %s", err.Error())
+		return
+	}
+
+	size := d.db.BatchSize()
+	for start := 0; start < len(models); start += size {
+		end := start + size
+		if end > len(models) {
+			end = len(models)
+		}
+
+		if err := elastic.BulkIndexContext(detachedContext{ctx}, d.es, d.esIndexName(), toDocuments(models[start:end])); err != nil {
+			logging.Errorf("bulk index failed: %s", err.Error())
+		}
+	}
+}
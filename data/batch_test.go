@@ -0,0 +1,71 @@
+package data_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+type BatchModel struct {
+	data.Model
+	Name string `gorm:"uniqueIndex"`
+	Age  int
+}
+
+func (BatchModel) TableName() string {
+	return "batch"
+}
+
+func TestBatch(t *testing.T) {
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: batch.db
+        automigrate: true
+        batchsize: 2
+`), "database")
+	defer os.RemoveAll("batch.db")
+
+	es := &recordingElastic{indexed: map[string]bool{}}
+	manager.GetDB("db1").SetElastic(es)
+	dao := manager.GetDaoForDb("db1", &BatchModel{})
+	assert.Equal(t, 2, manager.GetDB("db1").BatchSize())
+
+	values := []BatchModel{}
+	for i := 0; i < 5; i++ {
+		values = append(values, BatchModel{Name: fmt.Sprintf("user%d", i), Age: 20})
+	}
+	assert.Nil(t, dao.CreateBatch(values))
+	count, _ := dao.Count(nil)
+	assert.Equal(t, int64(5), count)
+	// generated uuids are written back to the slice and indexed in batches
+	assert.NotEmpty(t, values[0].UUID)
+	assert.True(t, es.indexed[values[4].UUID])
+	assert.Equal(t, 3, es.bulks)
+
+	// stored records are looked up in batches too
+	es.indexed = map[string]bool{}
+	updates := []*BatchModel{{Name: "user0", Age: 30}, {Name: "user1", Age: 30}, {Name: "user5", Age: 30}}
+	assert.Nil(t, dao.UpsertBatch(updates, []string{"name"}, []string{"age"}))
+	rs := []BatchModel{}
+	dao.Query(&data.QueryParams{"age": 30}, &rs)
+	assert.Equal(t, 3, len(rs))
+	count, _ = dao.Count(nil)
+	assert.Equal(t, int64(6), count)
+	// the existing record keeps its uuid on conflict, which is indexed instead of the new one in the value
+	assert.Equal(t, 3, es.indexedCount())
+	for _, r := range rs {
+		assert.True(t, es.indexed[r.UUID])
+	}
+	assert.Equal(t, values[0].UUID, rs[0].UUID)
+	assert.NotEqual(t, values[0].UUID, updates[0].UUID)
+	assert.False(t, es.indexed[updates[0].UUID])
+
+	assert.Nil(t, dao.CreateBatch([]BatchModel{}))
+	assert.NotNil(t, dao.CreateBatch(BatchModel{Name: "not a slice"}))
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const indexCommandUsage = `usage: <backfill|check|repair|reindex> [-db key] [-tables table1,table2]
+
+  backfill  index all records of the tables
+  check     compare the records with the indexes, failing if any index drifts
+  repair    index missing and stale documents, and delete orphaned ones
+  reindex   rebuild the indexes behind aliases without downtime`
+
+// IndexedDAOs are the registered daos of the database synced to elastic, sorted by table names.
+// Only the daos of tables are returned if they are specified.
+func (d *DataManager) IndexedDAOs(dbKey string, tables ...string) ([]*DAO, error) {
+	db, err := d.database(context.Background(), dbKey)
+	if err != nil {
+		return nil, err
+	}
+
+	d.lock.RLock()
+	found := map[string]*DAO{}
+	for _, daos := range d.daoMap {
+		for name, dao := range daos {
+			if dao.db == db && dao.es != nil {
+				dao := dao
+				found[name] = &dao
+			}
+		}
+	}
+	d.lock.RUnlock()
+
+	if len(tables) == 0 {
+		for name := range found {
+			tables = append(tables, name)
+		}
+		sort.Strings(tables)
+	}
+	result := make([]*DAO, 0, len(tables))
+	for _, table := range tables {
+		dao, ok := found[table]
+		if !ok {
+			return nil, fmt.Errorf("no dao synced to elastic for table %s in database %s", table, dbKey)
+		}
+		result = append(result, dao)
+	}
+	return result, nil
+}
+
+// RunIndexCommand runs an elastic index command with command line args. The models should be registered
+// before, so it's usually called in a small main of the service, e.g.
+//
+//	data.InitWithFile("config.yaml", "database")
+//	data.Manager().GetDAO(&model.User{})
+//	if err := data.Manager().RunIndexCommand(context.Background(), os.Args[1:], os.Stdout); err != nil {
+//		log.Fatal(err)
+//	}
+func (d *DataManager) RunIndexCommand(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(indexCommandUsage)
+	}
+	command := args[0]
+	switch command {
+	case "backfill", "check", "repair", "reindex":
+	default:
+		return fmt.Errorf("unknown command %s\n%s", command, indexCommandUsage)
+	}
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(out)
+	dbKey := flags.String("db", "", "key of the database in config, could be empty if there is only one")
+	tableList := flags.String("tables", "", "comma separated tables, all tables synced to elastic by default")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	tables := []string{}
+	for _, table := range strings.Split(*tableList, ",") {
+		if table = strings.TrimSpace(table); table != "" {
+			tables = append(tables, table)
+		}
+	}
+	daos, err := d.IndexedDAOs(*dbKey, tables...)
+	if err != nil {
+		return err
+	}
+
+	drifted := 0
+	for _, dao := range daos {
+		switch command {
+		case "backfill":
+			count, err := dao.BackfillContext(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s -> %s: %d records indexed\n", dao.Name(), dao.esIndexName(), count)
+		case "check":
+			report, err := dao.ReconcileContext(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, report.String())
+			if !report.InSync() {
+				drifted++
+			}
+		case "repair":
+			report, err := dao.RepairContext(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s, repaired\n", report.String())
+		case "reindex":
+			if err := dao.ReindexContext(ctx); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s -> %s: reindexed\n", dao.Name(), dao.esIndexName())
+		}
+	}
+
+	if drifted > 0 {
+		return fmt.Errorf("%d of %d indexes drifted", drifted, len(daos))
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+var gormLogLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
+
+// gorm config of the database entry. gorm defaults are kept if it's not defined, otherwise sql is logged
+// by the logging package at the matching levels, without record not found errors:
+//
+//	gorm:
+//	  loglevel: warn          # silent, error, warn or info
+//	  slowthreshold: 200ms    # sql slower than it is logged as warning
+//	  preparestmt: false
+//	  skipdefaulttransaction: false
+//	  tableprefix: ""
+//	  singulartable: false
+func newGormConfig(conf *config.Config) *gorm.Config {
+	var gormConf *config.Config
+	if conf != nil {
+		gormConf = conf.GetSubConfig("gorm")
+	}
+	if gormConf == nil {
+		return &gorm.Config{}
+	}
+
+	level, ok := gormLogLevels[strings.ToLower(gormConf.GetString("loglevel", "warn"))]
+	if !ok {
+		logging.Warnw("invalid gorm log level, using warn", "loglevel", gormConf.GetString("loglevel"))
+		level = logger.Warn
+	}
+	slowThreshold, err := time.ParseDuration(gormConf.GetString("slowthreshold", "200ms"))
+	if err != nil {
+		logging.Warnw("invalid gorm slow threshold, using 200ms", "slowthreshold", gormConf.GetString("slowthreshold"))
+		slowThreshold = 200 * time.Millisecond
+	}
+
+	return &gorm.Config{
+		Logger:                 newGormLogger(level, slowThreshold),
+		PrepareStmt:            gormConf.GetBool("preparestmt", false),
+		SkipDefaultTransaction: gormConf.GetBool("skipdefaulttransaction", false),
+		NamingStrategy: schema.NamingStrategy{
+			TablePrefix:   gormConf.GetString("tableprefix"),
+			SingularTable: gormConf.GetBool("singulartable", false),
+		},
+	}
+}
+
+// gorm logs are written by the logging package, so they share the same format and output
+type gormLogger struct {
+	level         logger.LogLevel
+	slowThreshold time.Duration
+}
+
+func newGormLogger(level logger.LogLevel, slowThreshold time.Duration) logger.Interface {
+	return &gormLogger{level: level, slowThreshold: slowThreshold}
+}
+
+func (l *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &gormLogger{level: level, slowThreshold: l.slowThreshold}
+}
+
+func (l *gormLogger) Info(ctx context.Context, format string, args ...interface{}) {
+	if l.level >= logger.Info {
+		logging.Infof(format, args...)
+	}
+}
+
+func (l *gormLogger) Warn(ctx context.Context, format string, args ...interface{}) {
+	if l.level >= logger.Warn {
+		logging.Warnf(format, args...)
+	}
+}
+
+func (l *gormLogger) Error(ctx context.Context, format string, args ...interface{}) {
+	if l.level >= logger.Error {
+		logging.Errorf(format, args...)
+	}
+}
+
+// failed sql as error, slow sql as warning, and all sql as info
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.level >= logger.Error && !errors.Is(err, logger.ErrRecordNotFound):
+		sql, rows := fc()
+		logging.Errorw("sql failed", "error", err.Error(), "sql", sql, "rows", rows, "elapsed", elapsed.String())
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= logger.Warn:
+		sql, rows := fc()
+		logging.Warnw("slow sql", "sql", sql, "rows", rows, "elapsed", elapsed.String(), "threshold", l.slowThreshold.String())
+	case l.level >= logger.Info:
+		sql, rows := fc()
+		logging.Infow("sql", "sql", sql, "rows", rows, "elapsed", elapsed.String())
+	}
+}
+
+// connection pool of the database entry, applied to the primary and replicas. Driver defaults are kept if not defined.
+//
+//	pool:
+//	  maxopen: 100        # max open connections
+//	  maxidle: 10         # max idle connections
+//	  maxlifetime: 1h     # max time a connection may be reused
+//	  maxidletime: 10m    # max time a connection may be idle
+func configurePool(db *gorm.DB, replicas []replica, conf *config.Config) error {
+	poolConf := conf.GetSubConfig("pool")
+	if poolConf == nil {
+		return nil
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	maxLifetime, err := parsePoolDuration(poolConf, "maxlifetime")
+	if err != nil {
+		return err
+	}
+	maxIdleTime, err := parsePoolDuration(poolConf, "maxidletime")
+	if err != nil {
+		return err
+	}
+
+	pools := []*sql.DB{sqlDB}
+	for _, r := range replicas {
+		pools = append(pools, r.db)
+	}
+	for _, pool := range pools {
+		if maxOpen := poolConf.GetInt("maxopen", 0); maxOpen > 0 {
+			pool.SetMaxOpenConns(maxOpen)
+		}
+		if maxIdle := poolConf.GetInt("maxidle", 0); maxIdle > 0 {
+			pool.SetMaxIdleConns(maxIdle)
+		}
+		if maxLifetime > 0 {
+			pool.SetConnMaxLifetime(maxLifetime)
+		}
+		if maxIdleTime > 0 {
+			pool.SetConnMaxIdleTime(maxIdleTime)
+		}
+	}
+	return nil
+}
+
+func parsePoolDuration(conf *config.Config, key string) (time.Duration, error) {
+	value := conf.GetString(key)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pool %s %s: %w", key, value, err)
+	}
+	return d, nil
+}
+
+// same database settings on another gorm handle, e.g. a transaction
+func (d *Database) withDB(db *gorm.DB) *Database {
+	return &Database{
+		DB:              *db,
+		automigrate:     d.automigrate,
+		elasticClient:   d.elasticClient,
+		elasticSettings: d.elasticSettings,
+		batchSize:       d.batchSize,
+		readPreference:  d.readPreference,
+		readPreferences: d.readPreferences,
+		resolver:        d.resolver,
+		replicas:        d.replicas,
+		outbox:          d.outbox,
+	}
+}
+
+// close the connection pools to the primary and replicas
+func (d *Database) close() {
+	closeConnections(&d.DB, d.replicas)
+}
+
+// close the connections opened so far, e.g. when creating the database fails afterwards. db could be nil.
+func closeConnections(db *gorm.DB, replicas []replica) {
+	if db != nil {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	for _, r := range replicas {
+		r.db.Close()
+	}
+}
+
+// Stats of the connection pool to the primary
+func (d *Database) Stats() (sql.DBStats, error) {
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	return sqlDB.Stats(), nil
+}
+
+// ReplicaStats of the connection pools to replicas by replica names in config
+func (d *Database) ReplicaStats() map[string]sql.DBStats {
+	result := map[string]sql.DBStats{}
+	for _, r := range d.replicas {
+		result[r.name] = r.db.Stats()
+	}
+	return result
+}
+
+// Stats of the connection pools of all databases, keyed by db key, and db_key.replica_name for replicas
+func (d *DataManager) Stats() map[string]sql.DBStats {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	result := map[string]sql.DBStats{}
+	for key, db := range d.databases {
+		stats, err := db.Stats()
+		if err != nil {
+			logging.Errorf("failed to get stats of %s: %s", key, err.Error())
+			continue
+		}
+		result[key] = stats
+		for name, replicaStats := range db.ReplicaStats() {
+			result[key+"."+name] = replicaStats
+		}
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package data_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm/logger"
+)
+
+func TestConnectionPool(t *testing.T) {
+	defer os.RemoveAll("pool.db")
+	defer os.RemoveAll("pool_replica.db")
+
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: pool.db
+        pool:
+            maxopen: 5
+            maxidle: 2
+            maxlifetime: 1h
+            maxidletime: 10m
+        gorm:
+            loglevel: error
+            slowthreshold: 1s
+            preparestmt: true
+            skipdefaulttransaction: true
+        replicas:
+          - replica1:
+              filepath: pool_replica.db
+`), "database")
+
+	db := manager.GetDB("db1")
+	assert.True(t, db.Config.PrepareStmt)
+	assert.True(t, db.Config.SkipDefaultTransaction)
+	assert.NotEqual(t, logger.Default, db.Config.Logger)
+
+	// gorm defaults are kept without the gorm block
+	plain, err := data.NewSqliteDatabase(config.NewConfigWithString(`filepath: pool.db`))
+	assert.Nil(t, err)
+	assert.Equal(t, logger.Default, plain.Config.Logger)
+	assert.False(t, plain.Config.PrepareStmt)
+
+	stats, err := db.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, stats.MaxOpenConnections)
+
+	all := manager.Stats()
+	assert.Equal(t, 2, len(all))
+	assert.Equal(t, 5, all["db1"].MaxOpenConnections)
+	assert.Equal(t, 5, all["db1.replica1"].MaxOpenConnections)
+
+	_, err = data.NewSqliteDatabase(config.NewConfigWithString(`
+filepath: pool.db
+pool:
+    maxlifetime: forever
+`))
+	assert.NotNil(t, err)
+}
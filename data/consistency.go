@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// ReadPreference decides where queries of daos with elastic are served from
+type ReadPreference string
+
+const (
+	// read from the primary database only, e.g. right after writes, since the index is refreshed asynchronously
+	ReadPrimary ReadPreference = "primary"
+	// read from elastic only, returning its error instead of scanning the database
+	ReadSearch ReadPreference = "search"
+	// read from elastic, falling back to the database on errors. The default
+	ReadSearchFallback ReadPreference = "fallback"
+)
+
+// Backend serving a query
+type Backend string
+
+const (
+	BackendSearch   Backend = "search"
+	BackendDatabase Backend = "database"
+)
+
+// QueryReport tells which backend served the last query with the context
+type QueryReport struct {
+	Backend Backend
+	// error of elastic when the query fell back to the database
+	SearchError error
+}
+
+type readPreferenceKey struct{}
+
+type queryReportKey struct{}
+
+// WithReadPreference overrides the read preference of the dao for queries with the returned context, e.g.
+//
+//	err := dao.QueryContext(data.WithReadPreference(ctx, data.ReadPrimary), query, &result)
+func WithReadPreference(ctx context.Context, preference ReadPreference) context.Context {
+	return context.WithValue(ctx, readPreferenceKey{}, preference)
+}
+
+// WithQueryReport fills report with the backend serving queries with the returned context, e.g.
+//
+//	report := &data.QueryReport{}
+//	err := dao.QueryContext(data.WithQueryReport(ctx, report), query, &result)
+//	if report.Backend == data.BackendDatabase && report.SearchError != nil { ... }
+func WithQueryReport(ctx context.Context, report *QueryReport) context.Context {
+	return context.WithValue(ctx, queryReportKey{}, report)
+}
+
+func parseReadPreference(s string) (ReadPreference, error) {
+	switch preference := ReadPreference(s); preference {
+	case "":
+		return ReadSearchFallback, nil
+	case ReadPrimary, ReadSearch, ReadSearchFallback:
+		return preference, nil
+	}
+	return "", fmt.Errorf("unsupported read preference %s, should be primary, search or fallback", s)
+}
+
+// SetReadPreference of queries, overriding the read and reads in cqrs config
+func (d *DAO) SetReadPreference(preference ReadPreference) {
+	d.read = preference
+}
+
+// read preference of the query with ctx. Queries in transactions and WithPrimary read from the primary.
+func (d *DAO) readPreference(ctx context.Context) ReadPreference {
+	if d.tx != nil || usePrimary(ctx) {
+		return ReadPrimary
+	}
+	if preference, ok := ctx.Value(readPreferenceKey{}).(ReadPreference); ok && preference != "" {
+		return preference
+	}
+	if d.read == "" {
+		return ReadSearchFallback
+	}
+	return d.read
+}
+
+// db for sql queries with ctx, on the primary for ReadPrimary, otherwise on a replica
+func (d *DAO) reader(ctx context.Context) *gorm.DB {
+	if d.readPreference(ctx) == ReadPrimary {
+		return d.db.primary(d.db.WithContext(ctx))
+	}
+	return d.db.reader(ctx)
+}
+
+// search from elastic by the read preference. It returns true if the result is searched,
+// or false if the query should be served by the database.
+func (d *DAO) trySearch(ctx context.Context, span trace.Span, search func() error) (bool, error) {
+	preference := d.readPreference(ctx)
+	if d.es == nil || preference == ReadPrimary {
+		d.report(ctx, span, BackendDatabase, nil)
+		return false, nil
+	}
+
+	err := search()
+	if err == nil {
+		d.report(ctx, span, BackendSearch, nil)
+		return true, nil
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if preference == ReadSearch {
+		return false, err
+	}
+
+	metrics.IncCQRSFallback(d.db.Name(), d.Name())
+	span.AddEvent("cqrs fallback to database")
+	d.report(ctx, span, BackendDatabase, err)
+	return false, nil
+}
+
+func (d *DAO) report(ctx context.Context, span trace.Span, backend Backend, searchErr error) {
+	span.SetAttributes(attribute.String("dao.backend", string(backend)))
+	if report, ok := ctx.Value(queryReportKey{}).(*QueryReport); ok && report != nil {
+		report.Backend = backend
+		report.SearchError = searchErr
+	}
+}
+
+// read preferences of the database in cqrs config:
+//
+//	cqrs:
+//	  type: elastic
+//	  name: elastic1
+//	  read: fallback      # primary, search or fallback (default)
+//	  reads:              # overrides of tables
+//	    orders: primary
+func setReadPreferences(db *Database, conf *config.Config) error {
+	preference, err := parseReadPreference(conf.GetString("read"))
+	if err != nil {
+		return err
+	}
+	db.readPreference = preference
+
+	db.readPreferences = map[string]ReadPreference{}
+	for table, value := range conf.GetStringMap("reads") {
+		if db.readPreferences[table], err = parseReadPreference(value); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return nil
+}
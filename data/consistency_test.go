@@ -0,0 +1,86 @@
+package data_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/stretchr/testify/assert"
+)
+
+// searchingElastic returns the indexed names for all searches
+type searchingElastic struct {
+	recordingElastic
+	names []string
+}
+
+func (e *searchingElastic) SearchContext(ctx context.Context, index string, termQueryType string, query map[string]interface{}, option *elastic.SearchOption) ([]map[string]interface{}, error) {
+	e.recordingElastic.SearchContext(ctx, index, termQueryType, query, option)
+	result := []map[string]interface{}{}
+	for _, name := range e.names {
+		result = append(result, map[string]interface{}{"Name": name})
+	}
+	return result, nil
+}
+
+func TestReadPreference(t *testing.T) {
+	defer os.RemoveAll("read.db")
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: read.db
+        automigrate: true
+        cqrs:
+            read: search
+            reads:
+                test2: primary
+`), "database")
+	ctx := context.Background()
+
+	// search is down for users, and stale for addresses
+	down := &recordingElastic{indexed: map[string]bool{}}
+	stale := &searchingElastic{recordingElastic: recordingElastic{indexed: map[string]bool{}}, names: []string{"deleted"}}
+	manager.GetDB("db1").SetElastic(down)
+	users := manager.GetDaoForDb("db1", &TestModel1{})
+	manager.GetDB("db1").SetElastic(stale)
+	addresses := manager.GetDaoForDb("db1", &TestModel2{})
+	assert.Nil(t, users.Create(&TestModel1{Name: "user1"}))
+	assert.Nil(t, addresses.Create(&TestModel2{Name: "address1"}))
+
+	// search only for users by config, failing without scanning the table
+	report := &data.QueryReport{}
+	result := []TestModel1{}
+	assert.NotNil(t, users.QueryContext(data.WithQueryReport(ctx, report), &data.QueryParams{}, &result))
+	assert.Equal(t, 0, len(result))
+
+	report = &data.QueryReport{}
+	err := users.QueryContext(data.WithQueryReport(data.WithReadPreference(ctx, data.ReadSearchFallback), report), &data.QueryParams{}, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, data.BackendDatabase, report.Backend)
+	assert.NotNil(t, report.SearchError)
+
+	// primary for addresses by config, never searched
+	report = &data.QueryReport{}
+	addressResult := []TestModel2{}
+	assert.Nil(t, addresses.QueryContext(data.WithQueryReport(ctx, report), &data.QueryParams{}, &addressResult))
+	assert.Equal(t, "address1", addressResult[0].Name)
+	assert.Equal(t, data.BackendDatabase, report.Backend)
+	assert.Nil(t, report.SearchError)
+	assert.Equal(t, 0, len(stale.searches))
+
+	addresses.SetReadPreference(data.ReadSearch)
+	_, err = addresses.QueryPageContext(data.WithQueryReport(ctx, report), &data.QueryParams{}, &addressResult, data.PageOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "deleted", addressResult[0].Name)
+	assert.Equal(t, data.BackendSearch, report.Backend)
+
+	// read your writes with primary
+	assert.Nil(t, addresses.QueryContext(data.WithQueryReport(data.WithPrimary(ctx), report), &data.QueryParams{}, &addressResult))
+	assert.Equal(t, "address1", addressResult[0].Name)
+	assert.Equal(t, data.BackendDatabase, report.Backend)
+}
@@ -1,12 +1,19 @@
 package data
 
 import (
+	"context"
+	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/skema-dev/skema-go/elastic"
 	"github.com/skema-dev/skema-go/event"
 	"github.com/skema-dev/skema-go/logging"
+	"github.com/skema-dev/skema-go/metrics"
+	"github.com/skema-dev/skema-go/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/schema"
@@ -34,6 +41,7 @@ const (
 )
 
 type eventData struct {
+	Ctx   context.Context
 	TX    *gorm.DB
 	Value DaoModel
 }
@@ -43,8 +51,23 @@ type DAO struct {
 	es            elastic.Elastic
 	columnToField map[string]string
 	fieldToColumn map[string]string
+	// primary key column, e.g. id
+	primaryKey string
+	// soft delete column, e.g. deleted_at. Empty if the model is not soft deleted
+	deletedAt string
+	// optimistic locking column, e.g. version. Empty if the model is not versioned
+	versionColumn string
+	// columns updated by Upsert on conflict without assigned columns, only for versioned models
+	upsertColumns []string
+	// elastic mappings of model fields: [field_name:mapping]
+	esFields map[string]esField
+	index    *indexState
+	// where queries are served from when elastic is enabled
+	read ReadPreference
 
 	pubsub *event.PubSub
+	// not nil when the dao is bound to a transaction
+	tx *TxScope
 }
 
 func NewDAO(db *Database, model DaoModel) *DAO {
@@ -53,9 +76,15 @@ func NewDAO(db *Database, model DaoModel) *DAO {
 		model:         model,
 		columnToField: map[string]string{},
 		fieldToColumn: map[string]string{},
+		esFields:      map[string]esField{},
+		index:         &indexState{},
 		pubsub:        event.NewPubSub(),
 	}
 	dao.initColumnFieldTable()
+	dao.read = db.readPreference
+	if preference, ok := db.readPreferences[model.TableName()]; ok {
+		dao.read = preference
+	}
 
 	// initiate even calling
 	f := func(v interface{}) {
@@ -69,7 +98,7 @@ func NewDAO(db *Database, model DaoModel) *DAO {
 			return
 		}
 
-		dao.UpdateElasticIndex(data.Value)
+		dao.updateElasticIndex(data.Ctx, data.Value)
 	}
 
 	dao.pubsub.Subscribe(eventOnDaoUpdate, f)
@@ -103,22 +132,79 @@ func (d *DAO) Automigrate() {
 }
 
 func (d *DAO) Create(value DaoModel) error {
-	tx := d.db.Create(value)
-	defer d.pubsub.Publish(eventOnDaoCreate, &eventData{tx, value})
+	return d.CreateContext(context.Background(), value)
+}
 
-	if tx.Error != nil {
-		logging.Errorf(tx.Error.Error())
+// CreateContext is the same as Create, running sql with ctx.
+// Elastic index is updated with the values in ctx but without its deadline, so it won't drift from the db.
+func (d *DAO) CreateContext(ctx context.Context, value DaoModel) (err error) {
+	ctx, span := d.startSpan(ctx, "create")
+	defer d.observe("create", time.Now(), span, &err)
+
+	d.initVersion(value)
+	var tx *gorm.DB
+	err = d.atomically(d.db.WithContext(ctx), func(db *gorm.DB) error {
+		tx = db.Create(value)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		return d.enqueue(db, value.PrimaryID())
+	})
+	defer d.publish(eventOnDaoCreate, &eventData{ctx, tx, value})
+
+	if err != nil {
+		logging.Errorf(err.Error())
 	}
 
-	return tx.Error
+	return err
 }
 
 func (d *DAO) Update(query *QueryParams, value DaoModel) error {
-	tx := d.db.Where(*query).Updates(value)
-	defer d.pubsub.Publish(eventOnDaoCreate, &eventData{tx, value})
+	return d.UpdateContext(context.Background(), query, value)
+}
 
-	if tx.Error != nil {
-		return tx.Error
+// UpdateContext is the same as Update, running sql with ctx.
+// For versioned models, value must carry the version it was read with, and ErrStaleObject is returned
+// if the record has been updated by others since then. The version in value is bumped on success.
+func (d *DAO) UpdateContext(ctx context.Context, query *QueryParams, value DaoModel) (err error) {
+	ctx, span := d.startSpan(ctx, "update")
+	defer d.observe("update", time.Now(), span, &err)
+
+	db, version, err := d.checkVersion(d.db.WithContext(ctx).Where(*query), value)
+	if err != nil {
+		return logging.Errorf(err.Error())
+	}
+	if v := d.versionOf(value); v.IsValid() {
+		// keep the version read by the caller on any failure, so it could retry with the same value
+		defer func() {
+			if err != nil {
+				v.SetInt(int64(version))
+			}
+		}()
+	}
+	var tx *gorm.DB
+	err = d.atomically(db, func(db *gorm.DB) error {
+		ids, err := d.outboxIDs(db)
+		if err != nil {
+			return err
+		}
+		tx = db.Updates(value)
+		if tx.Error != nil || tx.RowsAffected == 0 {
+			return tx.Error
+		}
+		// the uuid is updated too if it's set in value, so the old one is deleted from the index
+		if id := value.PrimaryID(); id != "" && (len(ids) != 1 || ids[0] != id) {
+			ids = append(ids, id)
+		}
+		return d.enqueue(db, ids...)
+	})
+	defer d.publish(eventOnDaoCreate, &eventData{ctx, tx, value})
+
+	if err != nil {
+		return err
+	}
+	if d.versionColumn != "" && tx.RowsAffected == 0 {
+		return &StaleObjectError{Table: d.model.TableName(), Version: version}
 	}
 
 	return nil
@@ -126,55 +212,128 @@ func (d *DAO) Update(query *QueryParams, value DaoModel) error {
 
 // Update if exists (by queryColumns), insert new one if not existing
 func (d *DAO) Upsert(value DaoModel, queryColumns []string, assignedColums []string) error {
+	return d.UpsertContext(context.Background(), value, queryColumns, assignedColums)
+}
+
+// UpsertContext is the same as Upsert, running sql with ctx
+func (d *DAO) UpsertContext(ctx context.Context, value DaoModel, queryColumns []string, assignedColums []string) (err error) {
+	ctx, span := d.startSpan(ctx, "upsert")
+	defer d.observe("upsert", time.Now(), span, &err)
+
 	var tx *gorm.DB
-	defer func() { d.pubsub.Publish(eventOnDaoCreate, &eventData{tx, value}) }()
+	defer func() { d.publish(eventOnDaoCreate, &eventData{ctx, tx, value}) }()
+	d.initVersion(value)
+
+	return d.atomically(d.db.WithContext(ctx), func(db *gorm.DB) error {
+		if queryColumns == nil || len(queryColumns) == 0 {
+			// no query columns exists, jut create new record
+			tx = db.Create(value)
+		} else {
+			tx = db.Clauses(d.onConflict(queryColumns, assignedColums)).Create(value)
+		}
+		if tx.Error != nil {
+			return tx.Error
+		}
 
-	if queryColumns == nil || len(queryColumns) == 0 {
-		// no query columns exists, jut create new record
-		tx = d.db.Create(value)
-		return tx.Error
-	}
+		ids, err := d.upsertedIDs(db, []DaoModel{value}, queryColumns)
+		if err != nil {
+			return err
+		}
+		return d.enqueue(db, ids...)
+	})
+}
 
+// conflict clause of upsert, updating assigned columns or all columns if not assigned
+func (d *DAO) onConflict(queryColumns []string, assignedColums []string) clause.OnConflict {
 	queries := []clause.Column{}
 	for _, col := range queryColumns {
 		queries = append(queries, clause.Column{Name: col})
 	}
 
+	if d.versionColumn != "" {
+		// versions are not checked in upsert, but still bumped so concurrent updates could detect the change
+		columns := assignedColums
+		if len(columns) == 0 {
+			columns = d.upsertColumns
+		}
+		return clause.OnConflict{
+			Columns:   queries,
+			DoUpdates: d.versionedAssignments(columns),
+		}
+	}
+
 	if assignedColums == nil && len(assignedColums) == 0 {
 		// no specific assignment column found, update all
-		tx = d.db.Clauses(clause.OnConflict{
+		return clause.OnConflict{
 			Columns:   queries,
 			UpdateAll: true,
-		}).Create(value)
-		return tx.Error
+		}
 	}
 
 	// update only assigned column when conflict happends
-	tx = d.db.Clauses(clause.OnConflict{
+	return clause.OnConflict{
 		Columns:   queries,
 		DoUpdates: clause.AssignmentColumns(assignedColums),
-	}).Create(value)
-
-	return tx.Error
+	}
 }
 
+// Query records into result. query could be *QueryParams for equality, or a Filter for complex conditions
 func (d *DAO) Query(
-	query *QueryParams,
+	query interface{},
+	result interface{},
+	options ...QueryOption,
+) error {
+	return d.QueryContext(context.Background(), query, result, options...)
+}
+
+// QueryContext is the same as Query, running elastic search and sql with ctx.
+// It won't fallback to db if ctx is done when searching from elastic.
+func (d *DAO) QueryContext(
+	ctx context.Context,
+	query interface{},
 	result interface{},
 	options ...QueryOption,
 ) error {
-	if d.es != nil {
-		if esSearchErr := d.searchFromElastic(query, result, options...); esSearchErr == nil {
-			return nil
+	return d.query(ctx, scopeActive, query, result, options...)
+}
+
+func (d *DAO) query(
+	ctx context.Context,
+	scope deleteScope,
+	query interface{},
+	result interface{},
+	options ...QueryOption,
+) (err error) {
+	ctx, span := d.startSpan(ctx, "query")
+	defer d.observe("query", time.Now(), span, &err)
+
+	if scope == scopeDeletedOnly && d.deletedAt == "" {
+		return logging.Errorf("%s is not soft deleted", d.Name())
+	}
+
+	searched, err := d.trySearch(ctx, span, func() error {
+		var searchOption *elastic.SearchOption
+		if len(options) > 0 {
+			searchOption = &elastic.SearchOption{
+				From: options[0].Offset,
+				Size: options[0].Limit,
+				Sort: options[0].Order,
+			}
 		}
+		return d.searchFromElastic(ctx, scope, query, result, searchOption)
+	})
+	if searched || err != nil {
+		return err
 	}
 
-	var tx *gorm.DB
+	tx, err := d.where(d.scoped(d.reader(ctx).Model(&d.model), scope), query)
+	if err != nil {
+		return logging.Errorf("invalid query for [%s]: %s", d.model.TableName(), err.Error())
+	}
 	if len(options) == 0 {
-		tx = d.db.Model(&d.model).Where(*query).Find(result)
+		tx = tx.Find(result)
 	} else {
 		option := options[0]
-		tx = d.db.Model(&d.model).Where(*query)
 		if len(option.Order) > 0 {
 			tx = tx.Order(option.Order)
 		}
@@ -191,7 +350,7 @@ func (d *DAO) Query(
 		logging.Errorf(
 			"query failed for [%s]. %v :  %s",
 			d.model.TableName(),
-			*query,
+			query,
 			tx.Error.Error(),
 		)
 	}
@@ -199,49 +358,162 @@ func (d *DAO) Query(
 	return tx.Error
 }
 
+// Count the records matching query in db. query could be *QueryParams or Filter
+func (d *DAO) Count(query interface{}) (int64, error) {
+	return d.CountContext(context.Background(), query)
+}
+
+// CountContext is the same as Count, running sql with ctx
+func (d *DAO) CountContext(ctx context.Context, query interface{}) (count int64, err error) {
+	ctx, span := d.startSpan(ctx, "count")
+	defer d.observe("count", time.Now(), span, &err)
+
+	tx, err := d.where(d.reader(ctx).Model(&d.model), query)
+	if err != nil {
+		return 0, logging.Errorf("invalid query for [%s]: %s", d.model.TableName(), err.Error())
+	}
+	tx = tx.Count(&count)
+	if tx.Error != nil {
+		logging.Errorf("count failed for [%s]. %v :  %s", d.model.TableName(), query, tx.Error.Error())
+	}
+
+	return count, tx.Error
+}
+
+// Delete records matching query. Besides *QueryParams and Filter, query could be any condition supported by gorm with args
 func (d *DAO) Delete(query interface{}, args ...interface{}) error {
-	ch := make(chan error)
-	ids := make([]string, 0)
+	return d.DeleteContext(context.Background(), query, args...)
+}
 
-	go func() {
-		rs := []map[string]interface{}{}
-		tx := d.db.Model(&d.model).Where(query, args...).Find(&rs)
-		if tx.Error != nil {
-			ch <- logging.Errorf(tx.Error.Error())
-			return
-		}
+// DeleteContext is the same as Delete, running sql and elastic requests with ctx
+func (d *DAO) DeleteContext(ctx context.Context, query interface{}, args ...interface{}) (err error) {
+	ctx, span := d.startSpan(ctx, "delete")
+	defer d.observe("delete", time.Now(), span, &err)
 
-		if len(rs) == 0 {
-			ch <- logging.Errorf("no matching record found")
-			return
-		}
-		for _, r := range rs {
-			ids = append(ids, r["uuid"].(string))
-		}
-		ch <- nil
-	}()
-	err := <-ch
+	db, err := d.where(d.db.WithContext(ctx).Model(&d.model), query, args...)
+	if err != nil {
+		return logging.Errorf("invalid query for [%s]: %s", d.model.TableName(), err.Error())
+	}
+	ids, err := d.matchingIDs(db)
 	if err != nil {
 		return err
 	}
+	if len(ids) == 0 {
+		return logging.Errorf("no matching record found")
+	}
+
+	if d.db.outbox != nil {
+		// soft deleted records are indexed again with DeletedAt, others are deleted from the index by the relay
+		return d.atomically(db, func(db *gorm.DB) error {
+			if err := db.Delete(&d.model).Error; err != nil {
+				return err
+			}
+			return d.enqueue(db, ids...)
+		})
+	}
+
+	if d.deletedAt != "" {
+		if err = db.Delete(&d.model).Error; err != nil {
+			return err
+		}
+		// soft deleted records stay in the index with DeletedAt, and are filtered out in searches
+		d.reindex(ctx, ids)
+		return nil
+	}
 
-	d.DeleteFromElastic(ids)
-	tx := d.db.Model(&d.model).Where(query, args...).Delete(&d.model)
+	d.afterWrite(ctx, func(ctx context.Context) { d.deleteFromElastic(ctx, ids) })
+	tx := db.Delete(&d.model)
 	return tx.Error
 }
 
+// uuids of the records matching db conditions, read from the primary since they are about to be written
+func (d *DAO) matchingIDs(db *gorm.DB) ([]string, error) {
+	rs := []map[string]interface{}{}
+	tx := d.db.primary(db.Session(&gorm.Session{})).Find(&rs)
+	if tx.Error != nil {
+		// keep the error chain, e.g. context.Canceled
+		logging.Errorf(tx.Error.Error())
+		return nil, tx.Error
+	}
+
+	column := d.idColumn()
+	ids := make([]string, 0, len(rs))
+	for _, r := range rs {
+		switch id := r[column].(type) {
+		case string:
+			ids = append(ids, id)
+		case []byte:
+			ids = append(ids, string(id))
+		case nil:
+			return nil, logging.Errorf("no %s in the records of [%s]", column, d.model.TableName())
+		default:
+			// numeric primary keys of models without uuid
+			ids = append(ids, fmt.Sprint(id))
+		}
+	}
+	return ids, nil
+}
+
+// run fn now, or after commit with a detached context if the dao is bound to a transaction
+func (d *DAO) afterWrite(ctx context.Context, fn func(ctx context.Context)) {
+	if d.tx != nil {
+		d.tx.afterCommit(func() { fn(detachedContext{ctx}) })
+		return
+	}
+	fn(ctx)
+}
+
+// publish the event now, or after commit if the dao is bound to a transaction.
+// Nothing is published with the outbox, which syncs the records instead.
+func (d *DAO) publish(eventName string, data *eventData) {
+	if d.db.outbox != nil {
+		return
+	}
+	if d.tx != nil {
+		d.tx.afterCommit(func() { d.pubsub.Publish(eventName, data) })
+		return
+	}
+	d.pubsub.Publish(eventName, data)
+}
+
+// start a span for the operation. The returned context should be passed to gorm, so sql spans are nested
+func (d *DAO) startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracing.StartInternal(ctx, "dao."+operation,
+		semconv.DBSystemKey.String(d.db.Name()),
+		semconv.DBSQLTableKey.String(d.Name()),
+		semconv.DBOperationKey.String(operation),
+	)
+}
+
+// record operation latency and error in metrics and end the span. err is a pointer to the named result
+func (d *DAO) observe(operation string, start time.Time, span trace.Span, err *error) {
+	metrics.ObserveDAO(d.db.Name(), d.Name(), operation, start, *err)
+	tracing.End(span, *err)
+}
+
 func (d *DAO) esIndexName() string {
 	return d.GetDB().Name() + "_" + d.model.TableName()
 }
 
 func (d *DAO) UpdateElasticIndex(data DaoModel) {
+	d.updateElasticIndex(context.Background(), data)
+}
+
+func (d *DAO) updateElasticIndex(ctx context.Context, data DaoModel) {
 	if d.es == nil {
 		return
 	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
 	ch := make(chan error)
 	go func(c chan error) {
-		c <- d.es.Index(d.esIndexName(), data.PrimaryID(), data)
+		if err := d.ensureIndex(detachedContext{ctx}); err != nil {
+			c <- err
+			return
+		}
+		c <- elastic.IndexContext(detachedContext{ctx}, d.es, d.esIndexName(), data.PrimaryID(), data)
 	}(ch)
 
 	result := <-ch
@@ -251,49 +523,46 @@ func (d *DAO) UpdateElasticIndex(data DaoModel) {
 }
 
 func (d *DAO) DeleteFromElastic(ids []string) {
+	d.deleteFromElastic(context.Background(), ids)
+}
+
+func (d *DAO) deleteFromElastic(ctx context.Context, ids []string) {
 	if d.es == nil {
 		return
 	}
-	d.es.Delete(d.esIndexName(), ids)
+	if err := elastic.DeleteContext(ctx, d.es, d.esIndexName(), ids); err != nil {
+		logging.Errorf("delete from index failed: %s", err.Error())
+	}
 }
 
 func (d *DAO) searchFromElastic(
-	query *QueryParams,
+	ctx context.Context,
+	scope deleteScope,
+	query interface{},
 	result interface{},
-	options ...QueryOption) error {
+	searchOption *elastic.SearchOption) error {
 	if d.es == nil {
 		return nil
 	}
-
-	newQuery := map[string]interface{}{}
-	for k, v := range *query {
-		fieldname, ok := d.columnToField[k]
-		if !ok {
-			logging.Errorf("missing column name: %s", k)
-			continue
-		}
-		newQuery[fieldname] = v
+	if err := d.ensureIndex(ctx); err != nil {
+		return logging.Errorf("failed to create elastic index %s: %s", d.esIndexName(), err.Error())
 	}
 
-	var searchOption *elastic.SearchOption
-
-	if len(options) > 0 {
-		searchOption = &elastic.SearchOption{
-			From: options[0].Offset,
-			Size: options[0].Limit,
-			Sort: options[0].Order,
-		}
+	filter, err := toFilter(query)
+	if err != nil {
+		return err
+	}
+	esQuery, err := d.scopeFilter(filter, scope).elasticQuery(d)
+	if err != nil {
+		return logging.Errorf("failed to build elastic query for %s: %s", d.esIndexName(), err.Error())
 	}
 
-	founds, err := d.es.Search(d.esIndexName(), "match", newQuery, searchOption)
+	founds, err := elastic.SearchContext(ctx, d.es, d.esIndexName(), "bool", map[string]interface{}{"must": esQuery}, searchOption)
 	if err != nil {
 		return logging.Errorf("Error happend when search from elastic for %s: %s", d.esIndexName(), err.Error())
 	}
 
-	modelType := reflect.TypeOf(d.model)
-	if modelType.Kind() == reflect.Ptr {
-		modelType = modelType.Elem()
-	}
+	modelType := d.modelType()
 
 	reflectedResult := reflect.ValueOf(result)
 	if reflect.TypeOf(result).Kind() == reflect.Ptr {
@@ -327,5 +596,72 @@ func (d *DAO) initColumnFieldTable() {
 		modelName := field.Name
 		d.columnToField[dbName] = modelName
 		d.fieldToColumn[modelName] = dbName
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			d.deletedAt = dbName
+		}
+		if field.FieldType == reflect.TypeOf(Version(0)) {
+			d.versionColumn = dbName
+		}
+		if property := jsonProperty(d.modelType(), field); property != "" && dbName != "" {
+			if mapping := elasticMapping(field); mapping != nil {
+				d.esFields[modelName] = esField{property: property, mapping: mapping}
+			}
+		}
+	}
+
+	if d.versionColumn != "" {
+		// same columns as UpdateAll of gorm, except the version
+		for _, field := range s.Fields {
+			if field.DBName == "" || field.PrimaryKey || field.AutoCreateTime > 0 || field.DBName == d.versionColumn {
+				continue
+			}
+			if field.HasDefaultValue && field.DefaultValueInterface == nil {
+				continue
+			}
+			d.upsertColumns = append(d.upsertColumns, field.DBName)
+		}
+	}
+
+	d.primaryKey = primaryIDColumn
+	if s.PrioritizedPrimaryField != nil {
+		d.primaryKey = s.PrioritizedPrimaryField.DBName
 	}
 }
+
+// column of PrimaryID(): uuid of data.Model, or the primary key of models without it
+func (d *DAO) idColumn() string {
+	if _, ok := d.columnToField[primaryIDColumn]; ok {
+		return primaryIDColumn
+	}
+	return d.primaryKey
+}
+
+// struct type of the model
+func (d *DAO) modelType() reflect.Type {
+	modelType := reflect.TypeOf(d.model)
+	if modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+	return modelType
+}
+
+// detachedContext keeps the values of the parent context (e.g. the trace span) without its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -1,6 +1,7 @@
 package data_test
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -35,6 +36,7 @@ func (s *daoTestSuite) TestDAOInSequence() {
 	s.testSampleDAO()
 	s.testCreateAndUpdateDAO()
 	s.testDeleteDAO()
+	s.testContextDAO()
 }
 
 func (s *daoTestSuite) testSampleDAO() {
@@ -161,6 +163,40 @@ filepath: './test.db'
 	os.RemoveAll("./test.db")
 }
 
+func (s *daoTestSuite) testContextDAO() {
+	yaml := `
+type: sqlite
+filepath: './test2.db'
+`
+	dbInstance, _ := db.NewSqliteDatabase(config.NewConfigWithString(yaml))
+	dao := db.NewDAO(dbInstance, &SampleModel{})
+	dao.Automigrate()
+
+	err := dao.CreateContext(context.Background(), &SampleModel{Name: "user1", Sex: "male", Nation: "china", City: "shanghai"})
+	assert.Nil(s.T(), err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = dao.CreateContext(ctx, &SampleModel{Name: "user2", Sex: "female", Nation: "england", City: "london"})
+	assert.ErrorIs(s.T(), err, context.Canceled)
+	err = dao.UpdateContext(ctx, &db.QueryParams{"name": "user1"}, &SampleModel{City: "beijing"})
+	assert.ErrorIs(s.T(), err, context.Canceled)
+
+	samples := []SampleModel{}
+	err = dao.QueryContext(ctx, &db.QueryParams{}, &samples)
+	assert.ErrorIs(s.T(), err, context.Canceled)
+	err = dao.DeleteContext(ctx, "name like 'user%'")
+	assert.ErrorIs(s.T(), err, context.Canceled)
+
+	err = dao.QueryContext(context.Background(), &db.QueryParams{}, &samples)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, len(samples))
+	assert.Equal(s.T(), "shanghai", samples[0].City)
+
+	os.RemoveAll("./test2.db")
+}
+
 func TestDaoTestSuite(t *testing.T) {
 	suite.Run(t, new(daoTestSuite))
 }
@@ -1,9 +1,8 @@
 package data
 
 import (
+	"context"
 	"errors"
-	"fmt"
-	"time"
 
 	"github.com/skema-dev/skema-go/config"
 	"github.com/skema-dev/skema-go/elastic"
@@ -12,19 +11,41 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
+const defaultBatchSize = 1000
+
 // wrap standard gorm.DB. For now, it's not doing much.
 type Database struct {
 	gorm.DB
 	automigrate   bool
 	elasticClient elastic.Elastic
+	// settings of elastic indexes, defined by settings in cqrs config
+	elasticSettings map[string]string
+	batchSize       int
+	// read preference of daos with elastic, defined by read and reads of tables in cqrs config
+	readPreference  ReadPreference
+	readPreferences map[string]ReadPreference
+	// routes reads to replicas, nil if there are no replicas
+	resolver *dbresolver.DBResolver
+	replicas []replica
+	// syncs records to elastic when enabled in cqrs config
+	outbox *Outbox
 }
 
 func (d Database) ShouldAutomigrate() bool {
 	return d.automigrate
 }
 
+// BatchSize of CreateBatch and UpsertBatch, defined by batchsize in config. 1000 by default
+func (d Database) BatchSize() int {
+	if d.batchSize <= 0 {
+		return defaultBatchSize
+	}
+	return d.batchSize
+}
+
 func (d *Database) SetElastic(client elastic.Elastic) {
 	d.elasticClient = client
 }
@@ -33,49 +54,71 @@ func (d *Database) Elastic() elastic.Elastic {
 	return d.elasticClient
 }
 
+// Ping the underlying database connection
+func (d *Database) Ping(ctx context.Context) error {
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
 // initiate mysql db and return the instance
 func NewMysqlDatabase(conf *config.Config) (*Database, error) {
-	username := conf.GetString("username")
-	password := conf.GetString("password")
-	host := conf.GetString("host")
-	port := conf.GetInt("port")
-	dbname := conf.GetString("dbname")
-	charset := conf.GetString("charset", "utf8mb4")
-	retries := conf.GetInt("retry", 0)
-
-	dsn := fmt.Sprintf(
-		"%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=True&loc=Local",
-		username,
-		password,
-		host,
-		port,
-		dbname,
-		charset,
-	)
-
-	logging.Debugf("connecting to %s", dsn)
-	db := retryConnectDatabase(retries, func() (*gorm.DB, error) {
-		return gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	return NewMysqlDatabaseContext(context.Background(), conf)
+}
+
+// NewMysqlDatabaseContext is the same as NewMysqlDatabase, retrying the connection until ctx is done
+func NewMysqlDatabaseContext(ctx context.Context, conf *config.Config) (*Database, error) {
+	dsn, err := mysqlDSN(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Debugf("connecting to %s", redactDSN(dsn))
+	db, err := connectDatabase(ctx, "mysql", conf, func() (*gorm.DB, error) {
+		return gorm.Open(mysql.Open(dsn), newGormConfig(conf))
 	})
-	if db == nil {
-		return nil, errors.New("failed to connect db")
+	if err != nil {
+		return nil, err
 	}
 
-	logging.Debugf("connectedto %s", dsn)
+	logging.Debugf("connectedto %s", redactDSN(dsn))
+
+	resolver, replicas, err := useReplicas(db, conf, func(c dsnConfig) (gorm.Dialector, error) {
+		replicaDSN, err := mysqlDSN(c)
+		return mysql.Open(replicaDSN), err
+	})
+	if err != nil {
+		closeConnections(db, nil)
+		return nil, err
+	}
+	if err = configurePool(db, replicas, conf); err != nil {
+		closeConnections(db, replicas)
+		return nil, err
+	}
 
 	return &Database{
 		DB:          *db,
 		automigrate: conf.GetBool("automigrate", false),
+		batchSize:   conf.GetInt("batchsize", defaultBatchSize),
+		resolver:    resolver,
+		replicas:    replicas,
 	}, nil
 }
 
 // initiate a sqlite db  for in-memeory implementing, and return the instance
 func NewMemoryDatabase(conf *config.Config) (*Database, error) {
-	db := retryConnectDatabase(0, func() (*gorm.DB, error) {
-		return gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	return NewMemoryDatabaseContext(context.Background(), conf)
+}
+
+// NewMemoryDatabaseContext is the same as NewMemoryDatabase
+func NewMemoryDatabaseContext(ctx context.Context, conf *config.Config) (*Database, error) {
+	db, err := connectDatabase(withoutRetry(ctx), "memory", conf, func() (*gorm.DB, error) {
+		return gorm.Open(sqlite.Open("file::memory:?cache=shared"), newGormConfig(conf))
 	})
-	if db == nil {
-		return nil, errors.New("failed to connect db")
+	if err != nil {
+		return nil, err
 	}
 
 	return &Database{
@@ -86,80 +129,77 @@ func NewMemoryDatabase(conf *config.Config) (*Database, error) {
 
 // initiate sqlite db and return the instance
 func NewSqliteDatabase(conf *config.Config) (*Database, error) {
-	dbfile := conf.GetString("filepath")
-	if dbfile == "" {
+	return NewSqliteDatabaseContext(context.Background(), conf)
+}
+
+// NewSqliteDatabaseContext is the same as NewSqliteDatabase, retrying the connection until ctx is done
+func NewSqliteDatabaseContext(ctx context.Context, conf *config.Config) (*Database, error) {
+	dsn := sqliteDSN(conf)
+	if dsn == "" {
 		return nil, errors.New("sqlite filepath is not defined")
 	}
 
-	db := retryConnectDatabase(0, func() (*gorm.DB, error) {
-		return gorm.Open(sqlite.Open(dbfile), &gorm.Config{})
+	db, err := connectDatabase(ctx, "sqlite", conf, func() (*gorm.DB, error) {
+		return gorm.Open(sqlite.Open(dsn), newGormConfig(conf))
 	})
-	if db == nil {
-		return nil, errors.New("failed to connect db")
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, replicas, err := useReplicas(db, conf, func(c dsnConfig) (gorm.Dialector, error) { return sqlite.Open(sqliteDSN(c)), nil })
+	if err != nil {
+		closeConnections(db, nil)
+		return nil, err
+	}
+	if err = configurePool(db, replicas, conf); err != nil {
+		closeConnections(db, replicas)
+		return nil, err
 	}
 
 	return &Database{
 		DB:          *db,
 		automigrate: conf.GetBool("automigrate", false),
+		batchSize:   conf.GetInt("batchsize", defaultBatchSize),
+		resolver:    resolver,
+		replicas:    replicas,
 	}, nil
 }
 
 // initiate postgresql db and return the instance
 func NewPostsqlDatabase(conf *config.Config) (*Database, error) {
-	username := conf.GetString("username")
-	password := conf.GetString("password")
-	host := conf.GetString("host")
-	port := conf.GetInt("port")
-	dbname := conf.GetString("dbname")
-	timezone := conf.GetString("timezone", "Asia/Shanghai")
-	retries := conf.GetInt("retry", 0)
-
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=%s",
-		host,
-		username,
-		password,
-		dbname,
-		port,
-		timezone,
-	)
-	options := conf.GetString("options")
-	if len(options) > 0 {
-		dsn += "options=" + options
-	}
+	return NewPostsqlDatabaseContext(context.Background(), conf)
+}
 
-	logging.Debugf("connecting to %s", dsn)
+// NewPostsqlDatabaseContext is the same as NewPostsqlDatabase, retrying the connection until ctx is done
+func NewPostsqlDatabaseContext(ctx context.Context, conf *config.Config) (*Database, error) {
+	dsn := postgresDSN(conf)
 
-	db := retryConnectDatabase(retries, func() (*gorm.DB, error) {
-		return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	logging.Debugf("connecting to %s", redactDSN(dsn))
+
+	db, err := connectDatabase(ctx, "pgsql", conf, func() (*gorm.DB, error) {
+		return gorm.Open(postgres.Open(dsn), newGormConfig(conf))
 	})
-	if db == nil {
-		return nil, errors.New("failed to connect db")
+	if err != nil {
+		return nil, err
 	}
 
-	logging.Debugf("connectedto %s", dsn)
+	logging.Debugf("connectedto %s", redactDSN(dsn))
+
+	resolver, replicas, err := useReplicas(db, conf, func(c dsnConfig) (gorm.Dialector, error) { return postgres.Open(postgresDSN(c)), nil })
+	if err != nil {
+		closeConnections(db, nil)
+		return nil, err
+	}
+	if err = configurePool(db, replicas, conf); err != nil {
+		closeConnections(db, replicas)
+		return nil, err
+	}
 
 	return &Database{
 		DB:          *db,
 		automigrate: conf.GetBool("automigrate", false),
+		batchSize:   conf.GetInt("batchsize", defaultBatchSize),
+		resolver:    resolver,
+		replicas:    replicas,
 	}, nil
 }
-
-func retryConnectDatabase(retryTimes int, fn func() (*gorm.DB, error)) *gorm.DB {
-	i := 0
-	for i <= retryTimes {
-		db, err := fn()
-		if err == nil {
-			return db
-		}
-		logging.Errorf(err.Error())
-
-		i += 1
-		if i <= retryTimes {
-			logging.Errorf("Failed to connect db. Attempt to retry in 3 seconds")
-			time.Sleep(3 * time.Second)
-		}
-	}
-
-	return nil
-}
@@ -1,6 +1,7 @@
 package data_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -51,6 +52,12 @@ func (s *databaseTestSuite) TestMemoryDb() {
 	assert.Equal(s.T(), "england", sample.Nation)
 }
 
+func (s *databaseTestSuite) TestPing() {
+	db, err := data.NewMemoryDatabase(nil)
+	assert.Nil(s.T(), err)
+	assert.Nil(s.T(), db.Ping(context.Background()))
+}
+
 func (s *databaseTestSuite) TestMysqlDb() {
 	mysqlConfigStr := `
 db1:
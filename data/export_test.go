@@ -0,0 +1,9 @@
+package data
+
+// unexported functions tested in data_test
+var (
+	MysqlDSN    = mysqlDSN
+	PostgresDSN = postgresDSN
+	RedactDSN   = redactDSN
+	Checksum    = checksum
+)
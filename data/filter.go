@@ -0,0 +1,427 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Filter is a query condition working for both the database and the elasticsearch read side.
+// Names in filters could be either column names or model field names, e.g.
+//
+//	filter := data.And(
+//		data.Eq("nation", "china"),
+//		data.Between("age", 18, 30),
+//		data.Or(data.Prefix("name", "user"), data.IsNull("city")),
+//		data.Not(data.In("sex", "male", "unknown")),
+//	)
+//	err := dao.Query(filter, &result)
+type Filter interface {
+	// render to gorm expression, nil means no condition
+	sqlExpr(d *DAO) (clause.Expression, error)
+	// render to elasticsearch query
+	elasticQuery(d *DAO) (map[string]interface{}, error)
+}
+
+const (
+	opEq     = "eq"
+	opNe     = "ne"
+	opGt     = "gt"
+	opGte    = "gte"
+	opLt     = "lt"
+	opLte    = "lte"
+	opIn     = "in"
+	opLike   = "like"
+	opPrefix = "prefix"
+	opMatch  = "match"
+)
+
+type compareFilter struct {
+	op    string
+	name  string
+	value interface{}
+	// names not in the model are used as they are, e.g. qualified columns in QueryParams
+	passThrough bool
+}
+
+type nullFilter struct {
+	name   string
+	isNull bool
+}
+
+type notFilter struct {
+	filter Filter
+}
+
+type groupFilter struct {
+	or      bool
+	filters []Filter
+}
+
+// Eq matches records with name = value. A slice value is the same as In, and nil is the same as IsNull
+func Eq(name string, value interface{}) Filter {
+	return &compareFilter{op: opEq, name: name, value: value}
+}
+
+// Ne matches records with name <> value
+func Ne(name string, value interface{}) Filter {
+	return &compareFilter{op: opNe, name: name, value: value}
+}
+
+func Gt(name string, value interface{}) Filter {
+	return &compareFilter{op: opGt, name: name, value: value}
+}
+
+func Gte(name string, value interface{}) Filter {
+	return &compareFilter{op: opGte, name: name, value: value}
+}
+
+func Lt(name string, value interface{}) Filter {
+	return &compareFilter{op: opLt, name: name, value: value}
+}
+
+func Lte(name string, value interface{}) Filter {
+	return &compareFilter{op: opLte, name: name, value: value}
+}
+
+// Between matches records with from <= name <= to
+func Between(name string, from interface{}, to interface{}) Filter {
+	return And(Gte(name, from), Lte(name, to))
+}
+
+// In matches records with name in values. A single slice is the same as its items, e.g. In("name", []string{"a", "b"})
+func In(name string, values ...interface{}) Filter {
+	if len(values) == 1 {
+		if items := sliceValues(values[0]); items != nil {
+			values = items
+		}
+	}
+	return &compareFilter{op: opIn, name: name, value: values}
+}
+
+// Like matches records with sql like pattern, using % and _ as wildcards
+func Like(name string, pattern string) Filter {
+	return &compareFilter{op: opLike, name: name, value: pattern}
+}
+
+func Prefix(name string, prefix string) Filter {
+	return &compareFilter{op: opPrefix, name: name, value: prefix}
+}
+
+// Match is a full text search in elasticsearch, and falls back to like %text% in database
+func Match(name string, text string) Filter {
+	return &compareFilter{op: opMatch, name: name, value: text}
+}
+
+func IsNull(name string) Filter {
+	return &nullFilter{name: name, isNull: true}
+}
+
+func NotNull(name string) Filter {
+	return &nullFilter{name: name, isNull: false}
+}
+
+// Not matches records not passing the filter. Not of a filter matching all records, e.g. And(), matches nothing.
+func Not(filter Filter) Filter {
+	return &notFilter{filter: filter}
+}
+
+// And matches records passing all filters. Empty And matches all records
+func And(filters ...Filter) Filter {
+	return &groupFilter{filters: filters}
+}
+
+// Or matches records passing any of the filters. Empty Or matches all records, the same as And
+func Or(filters ...Filter) Filter {
+	return &groupFilter{or: true, filters: filters}
+}
+
+// convert query to filter. query could be Filter, QueryParams or *QueryParams
+func toFilter(query interface{}) (Filter, error) {
+	switch q := query.(type) {
+	case nil:
+		return And(), nil
+	case Filter:
+		return q, nil
+	case QueryParams:
+		return paramsToFilter(q), nil
+	case *QueryParams:
+		if q == nil {
+			return And(), nil
+		}
+		return paramsToFilter(*q), nil
+	}
+	return nil, fmt.Errorf("unsupported query type %T", query)
+}
+
+func paramsToFilter(params QueryParams) Filter {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	// keep the generated sql stable
+	sort.Strings(names)
+
+	filters := make([]Filter, 0, len(params))
+	for _, name := range names {
+		// passed to gorm as they are, the same as before filters
+		filters = append(filters, &compareFilter{op: opEq, name: name, value: params[name], passThrough: true})
+	}
+	return And(filters...)
+}
+
+// apply query on db. Besides Filter and QueryParams, any condition supported by gorm Where works with args
+func (d *DAO) where(db *gorm.DB, query interface{}, args ...interface{}) (*gorm.DB, error) {
+	filter, err := toFilter(query)
+	if err != nil {
+		// other conditions supported by gorm, e.g. "name like ?" with args
+		return db.Where(query, args...), nil
+	}
+
+	expr, err := filter.sqlExpr(d)
+	if err != nil {
+		return nil, err
+	}
+	if expr == nil {
+		return db, nil
+	}
+	return db.Where(expr), nil
+}
+
+// resolve name to db column
+func (d *DAO) columnName(name string) (string, error) {
+	if _, ok := d.columnToField[name]; ok {
+		return name, nil
+	}
+	if column, ok := d.fieldToColumn[name]; ok {
+		return column, nil
+	}
+	return "", fmt.Errorf("unknown column %s in %s", name, d.Name())
+}
+
+// resolve name to elasticsearch document field
+func (d *DAO) fieldName(name string) (string, error) {
+	if field, ok := d.columnToField[name]; ok {
+		return field, nil
+	}
+	if _, ok := d.fieldToColumn[name]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown field %s in %s", name, d.Name())
+}
+
+// values of a slice, or nil if value is not a slice. []byte is not treated as slice
+func sliceValues(value interface{}) []interface{} {
+	if value == nil {
+		return nil
+	}
+	if _, ok := value.([]byte); ok {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil
+	}
+
+	result := make([]interface{}, v.Len())
+	for i := range result {
+		result[i] = v.Index(i).Interface()
+	}
+	return result
+}
+
+// convert sql like pattern to elasticsearch wildcard
+func likeToWildcard(pattern string) string {
+	replacer := strings.NewReplacer("*", `\*`, "?", `\?`, "%", "*", "_", "?")
+	return replacer.Replace(pattern)
+}
+
+func (f *compareFilter) sqlExpr(d *DAO) (clause.Expression, error) {
+	name, err := d.columnName(f.name)
+	if err != nil && !f.passThrough {
+		return nil, err
+	}
+	if err != nil {
+		name = f.name
+	}
+	column := clause.Column{Name: name}
+
+	switch f.op {
+	case opEq:
+		if values := sliceValues(f.value); values != nil {
+			return clause.IN{Column: column, Values: values}, nil
+		}
+		return clause.Eq{Column: column, Value: f.value}, nil
+	case opNe:
+		if values := sliceValues(f.value); values != nil {
+			return clause.Not(clause.IN{Column: column, Values: values}), nil
+		}
+		return clause.Neq{Column: column, Value: f.value}, nil
+	case opGt:
+		return clause.Gt{Column: column, Value: f.value}, nil
+	case opGte:
+		return clause.Gte{Column: column, Value: f.value}, nil
+	case opLt:
+		return clause.Lt{Column: column, Value: f.value}, nil
+	case opLte:
+		return clause.Lte{Column: column, Value: f.value}, nil
+	case opIn:
+		return clause.IN{Column: column, Values: f.value.([]interface{})}, nil
+	case opLike:
+		return clause.Like{Column: column, Value: f.value}, nil
+	case opPrefix:
+		return clause.Like{Column: column, Value: fmt.Sprintf("%s%%", f.value)}, nil
+	case opMatch:
+		return clause.Like{Column: column, Value: fmt.Sprintf("%%%s%%", f.value)}, nil
+	}
+	return nil, fmt.Errorf("unsupported filter operation %s", f.op)
+}
+
+func (f *compareFilter) elasticQuery(d *DAO) (map[string]interface{}, error) {
+	field, err := d.fieldName(f.name)
+	if err != nil && !f.passThrough {
+		return nil, err
+	}
+	if err != nil {
+		field = f.name
+	}
+
+	switch f.op {
+	case opEq:
+		if f.value == nil {
+			return boolQuery("must_not", map[string]interface{}{"exists": map[string]interface{}{"field": d.property(field)}}), nil
+		}
+		if values := sliceValues(f.value); values != nil {
+			return d.termsQuery(field, values), nil
+		}
+		return map[string]interface{}{"term": map[string]interface{}{d.keywordField(field, f.value): f.value}}, nil
+	case opNe:
+		q, err := (&compareFilter{op: opEq, name: f.name, value: f.value, passThrough: f.passThrough}).elasticQuery(d)
+		if err != nil {
+			return nil, err
+		}
+		return boolQuery("must_not", q), nil
+	case opGt, opGte, opLt, opLte:
+		return map[string]interface{}{"range": map[string]interface{}{d.property(field): map[string]interface{}{f.op: f.value}}}, nil
+	case opIn:
+		return d.termsQuery(field, f.value.([]interface{})), nil
+	case opLike:
+		pattern := likeToWildcard(f.value.(string))
+		return map[string]interface{}{"wildcard": map[string]interface{}{d.keywordField(field, pattern): map[string]interface{}{"value": pattern}}}, nil
+	case opPrefix:
+		return map[string]interface{}{"prefix": map[string]interface{}{d.keywordField(field, f.value): f.value}}, nil
+	case opMatch:
+		return map[string]interface{}{"match": map[string]interface{}{d.property(field): f.value}}, nil
+	}
+	return nil, fmt.Errorf("unsupported filter operation %s", f.op)
+}
+
+func (d *DAO) termsQuery(field string, values []interface{}) map[string]interface{} {
+	if len(values) == 0 {
+		return map[string]interface{}{"terms": map[string]interface{}{d.property(field): values}}
+	}
+	return map[string]interface{}{"terms": map[string]interface{}{d.keywordField(field, values[0]): values}}
+}
+
+func boolQuery(occur string, queries ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"bool": map[string]interface{}{occur: queries}}
+}
+
+func (f *nullFilter) sqlExpr(d *DAO) (clause.Expression, error) {
+	name, err := d.columnName(f.name)
+	if err != nil {
+		return nil, err
+	}
+	if f.isNull {
+		return clause.Eq{Column: clause.Column{Name: name}, Value: nil}, nil
+	}
+	return clause.Neq{Column: clause.Column{Name: name}, Value: nil}, nil
+}
+
+func (f *nullFilter) elasticQuery(d *DAO) (map[string]interface{}, error) {
+	field, err := d.fieldName(f.name)
+	if err != nil {
+		return nil, err
+	}
+	exists := map[string]interface{}{"exists": map[string]interface{}{"field": d.property(field)}}
+	if f.isNull {
+		return boolQuery("must_not", exists), nil
+	}
+	return exists, nil
+}
+
+func (f *notFilter) sqlExpr(d *DAO) (clause.Expression, error) {
+	expr, err := f.filter.sqlExpr(d)
+	if err != nil {
+		return nil, err
+	}
+	if expr == nil {
+		// not all records, the same as must_not match_all in elasticsearch
+		return clause.Expr{SQL: "1 = 0"}, nil
+	}
+	return clause.Not(expr), nil
+}
+
+func (f *notFilter) elasticQuery(d *DAO) (map[string]interface{}, error) {
+	q, err := f.filter.elasticQuery(d)
+	if err != nil {
+		return nil, err
+	}
+	return boolQuery("must_not", q), nil
+}
+
+func (f *groupFilter) sqlExpr(d *DAO) (clause.Expression, error) {
+	exprs := []clause.Expression{}
+	for _, filter := range f.filters {
+		expr, err := filter.sqlExpr(d)
+		if err != nil {
+			return nil, err
+		}
+		if expr == nil && f.or {
+			// any record passes a filter matching all, so does the group
+			return nil, nil
+		}
+		if expr != nil {
+			exprs = append(exprs, expr)
+		}
+	}
+
+	switch len(exprs) {
+	case 0:
+		return nil, nil
+	case 1:
+		// a single OrConditions is joined with OR by gorm, so return the expression directly
+		return exprs[0], nil
+	}
+	if f.or {
+		return clause.Or(exprs...), nil
+	}
+	return clause.And(exprs...), nil
+}
+
+func (f *groupFilter) elasticQuery(d *DAO) (map[string]interface{}, error) {
+	queries := []interface{}{}
+	for _, filter := range f.filters {
+		q, err := filter.elasticQuery(d)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+
+	if len(queries) == 0 {
+		return map[string]interface{}{"match_all": map[string]interface{}{}}, nil
+	}
+	if f.or {
+		return map[string]interface{}{"bool": map[string]interface{}{
+			"should":               queries,
+			"minimum_should_match": 1,
+		}}, nil
+	}
+	// must instead of filter, so full text match contributes to the score
+	return boolQuery("must", queries...), nil
+}
@@ -0,0 +1,117 @@
+package data_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+type FilterModel struct {
+	data.Model
+	Name   string
+	Age    int
+	Nation string
+	City   *string
+}
+
+func (FilterModel) TableName() string {
+	return "filter"
+}
+
+func TestFilterQuery(t *testing.T) {
+	dbInstance, _ := data.NewSqliteDatabase(config.NewConfigWithString(`filepath: filter.db`))
+	defer os.RemoveAll("filter.db")
+	dao := data.NewDAO(dbInstance, &FilterModel{})
+	dao.Automigrate()
+
+	city := "shanghai"
+	dao.Create(&FilterModel{Name: "user1", Age: 18, Nation: "china", City: &city})
+	dao.Create(&FilterModel{Name: "user2", Age: 25, Nation: "china"})
+	dao.Create(&FilterModel{Name: "user3", Age: 30, Nation: "japan"})
+	dao.Create(&FilterModel{Name: "admin", Age: 40, Nation: "france"})
+
+	names := func(query interface{}) []string {
+		rs := []FilterModel{}
+		assert.Nil(t, dao.Query(query, &rs, data.QueryOption{Order: "name"}))
+		result := []string{}
+		for _, r := range rs {
+			result = append(result, r.Name)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"user2", "user3"}, names(data.Between("age", 20, 30)))
+	assert.Equal(t, []string{"user1", "user2"}, names(data.And(data.Eq("nation", "china"), data.Lt("Age", 30))))
+	assert.Equal(t, []string{"admin", "user3"}, names(data.In("nation", []string{"japan", "france"})))
+	assert.Equal(t, []string{"user1", "user2", "user3"}, names(data.Prefix("name", "user")))
+	assert.Equal(t, []string{"admin"}, names(data.Like("name", "a_m%")))
+	assert.Equal(t, []string{"user1"}, names(data.NotNull("city")))
+	assert.Equal(t, []string{"admin", "user3"}, names(data.Not(data.Eq("nation", "china"))))
+	assert.Equal(t, []string{"admin", "user1"}, names(data.Or(data.IsNull("age"), data.Gt("age", 35), data.Eq("city", "shanghai"))))
+	assert.Equal(t, []string{"admin", "user1", "user2", "user3"}, names(data.And()))
+	assert.Equal(t, []string{"admin", "user1", "user2", "user3"}, names(data.Or()))
+	assert.Equal(t, []string{"admin", "user1", "user2", "user3"}, names(data.Or(data.And(), data.Eq("name", "admin"))))
+	assert.Equal(t, []string{}, names(data.Not(data.And())))
+	assert.Equal(t, []string{"user2"}, names(&data.QueryParams{"nation": "china", "age": 25}))
+	// names of QueryParams not in the model are passed to gorm as they are
+	assert.Equal(t, []string{"user3"}, names(&data.QueryParams{"filter.nation": "japan"}))
+
+	rs := []FilterModel{}
+	assert.NotNil(t, dao.Query(data.Eq("unknown", 1), &rs))
+	assert.NotNil(t, dao.Query(&data.QueryParams{"unknown": 1}, &rs))
+
+	count, err := dao.Count(data.Ne("nation", "china"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	assert.Nil(t, dao.Delete(data.Or(data.Eq("name", "admin"), data.Gte("age", 30))))
+	assert.Equal(t, []string{"user1", "user2"}, names(nil))
+}
+
+func TestFilterElasticQuery(t *testing.T) {
+	dbInstance, _ := data.NewMemoryDatabase(nil)
+	dao := data.NewDAO(dbInstance, &FilterModel{})
+	dao.Automigrate()
+	es := &recordingElastic{indexed: map[string]bool{}}
+	dao.SetElasticClient(es)
+
+	rs := []FilterModel{}
+	dao.Query(data.And(
+		data.Eq("nation", "china"),
+		data.Or(data.Gte("age", 18), data.IsNull("city")),
+		data.Not(data.Prefix("name", "admin")),
+		data.Match("name", "user"),
+	), &rs)
+
+	// soft deleted documents are excluded
+	expected := `{"bool":{"must":{"bool":{"must":[
+		{"bool":{"must":[
+			{"term":{"Nation.keyword":"china"}},
+			{"bool":{"minimum_should_match":1,"should":[
+				{"range":{"Age":{"gte":18}}},
+				{"bool":{"must_not":[{"exists":{"field":"City"}}]}}
+			]}},
+			{"bool":{"must_not":[{"prefix":{"Name.keyword":"admin"}}]}},
+			{"match":{"Name":"user"}}
+		]}},
+		{"bool":{"must_not":[{"exists":{"field":"DeletedAt"}}]}}
+	]}}}}`
+	actual, _ := json.Marshal(es.searches[0])
+	assert.JSONEq(t, expected, string(actual))
+
+	// empty groups match all, and not all matches nothing, the same as sql
+	dao.Query(data.Or(data.Not(data.And()), data.Or()), &rs)
+	expected = `{"bool":{"must":{"bool":{"must":[
+		{"bool":{"minimum_should_match":1,"should":[
+			{"bool":{"must_not":[{"match_all":{}}]}},
+			{"match_all":{}}
+		]}},
+		{"bool":{"must_not":[{"exists":{"field":"DeletedAt"}}]}}
+	]}}}}`
+	actual, _ = json.Marshal(es.searches[1])
+	assert.JSONEq(t, expected, string(actual))
+}
@@ -1,11 +1,17 @@
 package data
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/skema-dev/skema-go/config"
 	"github.com/skema-dev/skema-go/elastic"
+	"github.com/skema-dev/skema-go/health"
 	"github.com/skema-dev/skema-go/logging"
 )
 
@@ -14,16 +20,35 @@ type DataManager struct {
 	databases map[string]*Database
 	// [db_key:[table_name:model]]
 	daoMap map[string]map[string]DAO
+	// sql migration files of each database: [db_key:dir]
+	migrationDirs map[string]string
+	// databases not connected yet in degraded mode: [db_key:pending]
+	pending map[string]*pendingDatabase
+	// start with unavailable databases instead of exiting
+	degraded bool
+	// guards databases, pending and daoMap, which are also read by the outbox relay and lazy connections
+	lock sync.RWMutex
 
 	elasticClient elastic.Elastic
 }
 
+// database connecting lazily in degraded mode
+type pendingDatabase struct {
+	dbType         string
+	conf           *config.Config
+	originalConfig *config.Config
+	backoff        backoff
+	attempts       int
+	next           time.Time
+	connecting     bool
+}
+
 var (
-	dbCreateMap = map[string]func(*config.Config) (*Database, error){
-		"mysql":  NewMysqlDatabase,
-		"memory": NewMemoryDatabase,
-		"sqlite": NewSqliteDatabase,
-		"pgsql":  NewPostsqlDatabase,
+	dbCreateMap = map[string]func(context.Context, *config.Config) (*Database, error){
+		"mysql":  NewMysqlDatabaseContext,
+		"memory": NewMemoryDatabaseContext,
+		"sqlite": NewSqliteDatabaseContext,
+		"pgsql":  NewPostsqlDatabaseContext,
 	}
 
 	// model type registry: [package:[typeName: type]]
@@ -38,7 +63,12 @@ func InitWithFile(filepath string, key string) {
 }
 
 func InitWithConfig(conf *config.Config, key string) {
-	dataMan = NewDataManager().WithConfig(conf, key)
+	InitWithConfigContext(context.Background(), conf, key)
+}
+
+// InitWithConfigContext is the same as InitWithConfig, giving up connection retries when ctx is done
+func InitWithConfigContext(ctx context.Context, conf *config.Config, key string) {
+	dataMan = NewDataManager().WithConfigContext(ctx, conf, key)
 }
 
 func R(model DaoModel) {
@@ -66,26 +96,46 @@ func Manager() *DataManager {
 
 func NewDataManager() *DataManager {
 	man := &DataManager{
-		databases: map[string]*Database{},
-		daoMap:    map[string]map[string]DAO{},
+		databases:     map[string]*Database{},
+		daoMap:        map[string]map[string]DAO{},
+		migrationDirs: map[string]string{},
+		pending:       map[string]*pendingDatabase{},
 	}
 	return man
 }
 
+// WithDegradedStart keeps running when databases can't be connected after retries, instead of exiting.
+// Unavailable databases are connected lazily by GetDB and health checks, at most once per retry interval.
+// It could also be enabled for a single database by startdegraded in config.
+func (d *DataManager) WithDegradedStart() *DataManager {
+	d.degraded = true
+	return d
+}
+
 func (d *DataManager) WithConfig(conf *config.Config, key string) *DataManager {
+	return d.WithConfigContext(context.Background(), conf, key)
+}
+
+// WithConfigContext is the same as WithConfig, giving up connection retries when ctx is done
+func (d *DataManager) WithConfigContext(ctx context.Context, conf *config.Config, key string) *DataManager {
 	if conf == nil {
 		return d
 	}
 
 	confs := conf.GetMapConfig(key)
 	for k, v := range confs {
-		d.AddDatabaseWithConfig(&v, k, conf)
+		d.AddDatabaseWithConfigContext(ctx, &v, k, conf)
 	}
 
 	return d
 }
 
 func (d *DataManager) AddDatabaseWithConfig(conf *config.Config, dbKey string, originalConfig *config.Config) {
+	d.AddDatabaseWithConfigContext(context.Background(), conf, dbKey, originalConfig)
+}
+
+// AddDatabaseWithConfigContext is the same as AddDatabaseWithConfig, giving up connection retries when ctx is done
+func (d *DataManager) AddDatabaseWithConfigContext(ctx context.Context, conf *config.Config, dbKey string, originalConfig *config.Config) {
 	logging.Debugf("Add Database for %s", dbKey)
 	if dbKey == "" {
 		logging.Fatalf("AddDatabaseWithConfig must specify a key for the db!")
@@ -98,19 +148,55 @@ func (d *DataManager) AddDatabaseWithConfig(conf *config.Config, dbKey string, o
 		logging.Fatalf("database type %s is not supported", dbtype)
 	}
 
-	db, err := createFn(conf)
-	if err != nil {
-		logging.Fatalf("failed creating database")
-	}
-
-	d.databases[dbKey] = db
+	health.Register("database:"+dbKey, func(ctx context.Context) error {
+		db, err := d.database(ctx, dbKey)
+		if err != nil {
+			return err
+		}
+		return db.Ping(ctx)
+	})
 
-	models := conf.GetMapFromArray("models")
-	if models != nil {
-		d.initDaoModelForDb(dbKey, models)
+	db, err := createFn(ctx, conf)
+	if err == nil {
+		err = d.setupDatabase(dbKey, db, conf, originalConfig)
+	}
+	if err != nil {
+		if !d.degraded && !conf.GetBool("startdegraded", false) {
+			logging.Fatalf("failed creating database %s: %s", dbKey, err.Error())
+		}
+		logging.Errorf("database %s is unavailable, starting degraded: %s", dbKey, err.Error())
+
+		b := newBackoff(conf)
+		d.lock.Lock()
+		d.pending[dbKey] = &pendingDatabase{
+			dbType:         dbtype,
+			conf:           conf,
+			originalConfig: originalConfig,
+			backoff:        b,
+			attempts:       1,
+			next:           time.Now().Add(b.delay(1)),
+		}
+		d.lock.Unlock()
 	}
+}
 
-	// check if elasticsearch is defined
+// run migrations of the connected database, then register it and create daos of models in config.
+// It runs with a background context, so lazy connections are not interrupted by the caller's ctx.
+// The database is closed and not registered if any step fails.
+func (d *DataManager) setupDatabase(dbKey string, db *Database, conf *config.Config, originalConfig *config.Config) (err error) {
+	ctx := context.Background()
+	defer func() {
+		if err == nil {
+			return
+		}
+		d.lock.Lock()
+		delete(d.databases, dbKey)
+		delete(d.daoMap, dbKey)
+		d.lock.Unlock()
+		db.close()
+	}()
+
+	// check if elasticsearch is defined, before daos of models are created
 	queryConf := conf.GetSubConfig("cqrs")
 	if queryConf != nil {
 		queryType := queryConf.GetString("type")
@@ -118,9 +204,99 @@ func (d *DataManager) AddDatabaseWithConfig(conf *config.Config, dbKey string, o
 			elasticConfigKey := queryConf.GetString("name")
 			client := elastic.NewElasticClient(originalConfig.GetSubConfig(elasticConfigKey))
 			db.SetElastic(client)
+			db.elasticSettings = queryConf.GetStringMap("settings")
+			health.Register("elastic:"+elasticConfigKey, func(ctx context.Context) error { return elastic.Ping(ctx, client) })
+		}
+
+		if err := setReadPreferences(db, queryConf); err != nil {
+			return logging.Errorf("invalid cqrs config for %s: %s", dbKey, err.Error())
+		}
+
+		if outboxConf := queryConf.GetSubConfig("outbox"); outboxConf != nil {
+			outbox, err := newOutbox(d, dbKey, db, outboxConf)
+			if err != nil {
+				return logging.Errorf("failed to enable outbox for %s: %s", dbKey, err.Error())
+			}
+			db.outbox = outbox
+		}
+	}
+
+	// versioned migrations run before models are migrated
+	migrationConf := conf.GetSubConfig("migrations")
+	if migrationConf != nil {
+		d.lock.Lock()
+		d.migrationDirs[dbKey] = migrationConf.GetString("dir")
+		d.lock.Unlock()
+		if migrationConf.GetBool("run", false) {
+			migrator, err := d.newMigrator(dbKey, db)
+			if err == nil {
+				err = migrator.Up(ctx)
+			}
+			if err != nil {
+				return logging.Errorf("failed to run migrations for %s: %s", dbKey, err.Error())
+			}
+		}
+	}
+
+	d.lock.Lock()
+	d.databases[dbKey] = db
+	d.lock.Unlock()
+
+	models := conf.GetMapFromArray("models")
+	if models != nil {
+		if err = d.initDaoModelForDb(dbKey, models); err != nil {
+			return err
 		}
 	}
 
+	// started after daos of models are created, so their events are not taken as unregistered
+	if db.outbox != nil {
+		db.outbox.Start()
+	}
+	return nil
+}
+
+// try connecting pending databases with dbKey, or all of them with empty key. Returns true if any is connected.
+func (d *DataManager) connectPending(ctx context.Context, dbKey string) bool {
+	now := time.Now()
+	d.lock.Lock()
+	keys := []string{}
+	for key, p := range d.pending {
+		if (dbKey == "" || key == dbKey) && !p.connecting && !now.Before(p.next) {
+			p.connecting = true
+			keys = append(keys, key)
+		}
+	}
+	d.lock.Unlock()
+
+	connected := false
+	for _, key := range keys {
+		d.lock.RLock()
+		p := d.pending[key]
+		d.lock.RUnlock()
+
+		db, err := dbCreateMap[p.dbType](withoutRetry(ctx), p.conf)
+		if err == nil {
+			// still pending if setup fails, and retried after the interval
+			err = d.setupDatabase(key, db, p.conf, p.originalConfig)
+		}
+		if err != nil {
+			d.lock.Lock()
+			p.connecting = false
+			p.attempts++
+			p.next = time.Now().Add(p.backoff.delay(p.attempts))
+			d.lock.Unlock()
+			logging.Warnw("database is still unavailable", "db", key, "attempts", p.attempts, "error", err.Error())
+			continue
+		}
+
+		d.lock.Lock()
+		delete(d.pending, key)
+		d.lock.Unlock()
+		logging.Infow("database connected", "db", key, "attempts", p.attempts+1)
+		connected = true
+	}
+	return connected
 }
 
 //
@@ -130,7 +306,7 @@ func (d *DataManager) AddDatabaseWithConfig(conf *config.Config, dbKey string, o
 //        package: xxxxxx (optional)
 //
 //
-func (d *DataManager) initDaoModelForDb(dbkey string, models map[string]interface{}) {
+func (d *DataManager) initDaoModelForDb(dbkey string, models map[string]interface{}) error {
 	for modelTypeName, v := range models {
 		var daoModel DaoModel
 
@@ -145,11 +321,11 @@ func (d *DataManager) initDaoModelForDb(dbkey string, models map[string]interfac
 				// package specified, look into the specific registry map
 				types, ok := modelTypeRegistry[pkg.(string)]
 				if !ok {
-					logging.Fatalw("incorrect package when initializing dao", "package", pkg, "mode type", modelTypeName)
+					return logging.Errorf("incorrect package %v when initializing dao of %s", pkg, modelTypeName)
 				}
 				modelType, ok := types[modelTypeName]
 				if !ok {
-					logging.Fatalw("incorrect type name when initializing dao", "package", pkg, "mode type", modelTypeName, "types", types)
+					return logging.Errorf("incorrect type name %s in package %v when initializing dao", modelTypeName, pkg)
 				}
 				daoModel = reflect.New(modelType).Elem().Interface().(DaoModel)
 			} else {
@@ -158,18 +334,19 @@ func (d *DataManager) initDaoModelForDb(dbkey string, models map[string]interfac
 		}
 
 		if daoModel == nil {
-			logging.Fatalw("incorrect definition for model", "model name", modelTypeName, "config", v)
+			return logging.Errorf("incorrect definition for model %s: %v", modelTypeName, v)
 		}
 
 		dao := d.GetDaoForDb(dbkey, daoModel)
 		if dao == nil {
-			logging.Fatalf("failed to create dao for %s:%s", dbkey, daoModel.TableName())
+			return logging.Errorf("failed to create dao for %s:%s", dbkey, daoModel.TableName())
 		}
 	}
+	return nil
 }
 
 // find model type in the whole type registry tables
-func (d DataManager) findModelType(modelTypeName string) DaoModel {
+func (d *DataManager) findModelType(modelTypeName string) DaoModel {
 	for _, models := range modelTypeRegistry {
 		modelType, ok := models[modelTypeName]
 		if ok {
@@ -180,27 +357,68 @@ func (d DataManager) findModelType(modelTypeName string) DaoModel {
 }
 
 // Get the underlying database object
-func (d DataManager) GetDB(dbKey string) *Database {
+func (d *DataManager) GetDB(dbKey string) *Database {
+	db, err := d.database(context.Background(), dbKey)
+	if err != nil {
+		logging.Errorf(err.Error())
+		return nil
+	}
+	return db
+}
+
+// database with dbKey, connecting it if it's pending in degraded mode
+func (d *DataManager) database(ctx context.Context, dbKey string) (*Database, error) {
+	db, err := d.lookupDB(dbKey)
+	if errors.Is(err, ErrDatabaseUnavailable) && d.connectPending(ctx, dbKey) {
+		db, err = d.lookupDB(dbKey)
+	}
+	return db, err
+}
+
+func (d *DataManager) lookupDB(dbKey string) (*Database, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
 	if dbKey == "" {
 		// no key specified, return the db if there is only one, otherwise fatal exit
-		if len(d.databases) > 1 {
-			logging.Errorf("more than 1 database defined. Please specify the exact db with a key")
-			return nil
+		if len(d.databases)+len(d.pending) > 1 {
+			return nil, errors.New("more than 1 database defined. Please specify the exact db with a key")
 		}
 
 		for _, v := range d.databases {
 			logging.Debugf("no database key specified, return the default db")
-			return v
+			return v, nil
+		}
+		for k := range d.pending {
+			return nil, fmt.Errorf("%w: %s", ErrDatabaseUnavailable, k)
 		}
 	}
 
 	db, ok := d.databases[dbKey]
-	if !ok {
-		logging.Errorf("cannot find database with key %s", dbKey)
-		return nil
+	if ok {
+		return db, nil
+	}
+	if _, ok := d.pending[dbKey]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrDatabaseUnavailable, dbKey)
 	}
+	return nil, fmt.Errorf("cannot find database with key %s", dbKey)
+}
 
-	return db
+// find a registered dao without creating it
+func (d *DataManager) findDao(dbKey string, tableName string) *DAO {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	if dao, ok := d.daoMap[dbKey][tableName]; ok {
+		return &dao
+	}
+	// daos fetched by GetDAO() are registered with empty key for the default db
+	if dbKey != "" && len(d.databases) == 1 {
+		if dao, ok := d.daoMap[""][tableName]; ok {
+			return &dao
+		}
+	}
+	return nil
 }
 
 func (d *DataManager) GetDAO(model DaoModel) *DAO {
@@ -215,6 +433,7 @@ func (d *DataManager) GetDaoForDb(dbKey string, model DaoModel) *DAO {
 		return nil
 	}
 
+	d.lock.Lock()
 	dbs, ok := d.daoMap[dbKey]
 	if !ok {
 		dbs = make(map[string]DAO)
@@ -223,14 +442,17 @@ func (d *DataManager) GetDaoForDb(dbKey string, model DaoModel) *DAO {
 
 	daoIns, ok := d.daoMap[dbKey][model.TableName()]
 	if ok {
+		d.lock.Unlock()
 		return &daoIns
 	}
 
 	newDao := NewDAO(db, model)
+	// set before registering, the map keeps a copy
+	newDao.SetElasticClient(db.Elastic())
 	dbs[model.TableName()] = *newDao
+	d.lock.Unlock()
 	logging.Debugw("DAO not found. New DAO created", "db", dbKey, "table", model.TableName())
 
-	newDao.SetElasticClient(db.Elastic())
 	// now initialize the table if necessary
 	if db.ShouldAutomigrate() {
 		db.AutoMigrate(model)
@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm/schema"
+)
+
+// ElasticSettings could be implemented by models to define the settings of their index, e.g. custom analyzers.
+// They override the settings in cqrs config.
+type ElasticSettings interface {
+	ElasticSettings() map[string]interface{}
+}
+
+// mapping of a document field, from the es tag or the default of its type
+type esField struct {
+	// property in the document, the json name of the field
+	property string
+	mapping  map[string]interface{}
+}
+
+// index creation shared by the copies of a dao
+type indexState struct {
+	lock    sync.Mutex
+	created bool
+}
+
+// mapping of the model field, nil if it's not mapped explicitly. The default mapping could be overridden by es tag:
+//
+//	UserID string `es:"keyword"`
+//	Title  string `es:"text,analyzer=ik_max_word,search_analyzer=ik_smart"`
+//	Name   string `es:"text,analyzer=ik,keyword"`   // with keyword sub field for exact match and sort
+//	Born   string `es:"date,format=yyyy-MM-dd"`
+func elasticMapping(field *schema.Field) map[string]interface{} {
+	if tag, ok := field.Tag.Lookup("es"); ok && tag != "" {
+		return parseElasticTag(tag)
+	}
+
+	switch field.DataType {
+	case schema.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case schema.Int, schema.Uint:
+		return map[string]interface{}{"type": "long"}
+	case schema.Float:
+		return map[string]interface{}{"type": "double"}
+	case schema.Time:
+		return map[string]interface{}{"type": "date"}
+	case schema.Bytes:
+		return map[string]interface{}{"type": "binary"}
+	case schema.String:
+		if field.DBName == primaryIDColumn {
+			return map[string]interface{}{"type": "keyword"}
+		}
+		// same as dynamic mapping
+		return map[string]interface{}{"type": "text", "fields": keywordSubField()}
+	}
+	return nil
+}
+
+func parseElasticTag(tag string) map[string]interface{} {
+	options := strings.Split(tag, ",")
+	mapping := map[string]interface{}{"type": strings.TrimSpace(options[0])}
+	for _, option := range options[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+		switch {
+		case !ok && key == "keyword":
+			mapping["fields"] = keywordSubField()
+		case !ok:
+			mapping[key] = true
+		default:
+			mapping[key] = parseElasticValue(value)
+		}
+	}
+	return mapping
+}
+
+func parseElasticValue(value string) interface{} {
+	if b, err := strconv.ParseBool(value); err == nil {
+		return b
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	return value
+}
+
+func keywordSubField() map[string]interface{} {
+	return map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256}}
+}
+
+// json name of the field in the document, or empty if it's not in the document.
+// Fields of embedded structs are only flattened for anonymous ones, e.g. Model.
+func jsonProperty(modelType reflect.Type, field *schema.Field) string {
+	t := modelType
+	for i, index := range field.StructField.Index {
+		if index < 0 {
+			// pointer to embedded struct
+			index = -index - 1
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || index >= t.NumField() {
+			return ""
+		}
+		f := t.Field(index)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if i == len(field.StructField.Index)-1 {
+			if name == "" {
+				name = f.Name
+			}
+			return name
+		}
+		if !f.Anonymous || name != "" {
+			return ""
+		}
+		t = f.Type
+	}
+	return ""
+}
+
+// IndexTemplate of the elastic index, with the mappings derived from the model schema and es tags,
+// and the settings defined in cqrs config and by the model
+func (d *DAO) IndexTemplate() map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, field := range d.esFields {
+		properties[field.property] = field.mapping
+	}
+	template := map[string]interface{}{
+		"mappings": map[string]interface{}{"properties": properties},
+	}
+
+	settings := map[string]interface{}{}
+	for key, value := range d.db.elasticSettings {
+		settings[key] = value
+	}
+	if model, ok := d.model.(ElasticSettings); ok {
+		for key, value := range model.ElasticSettings() {
+			settings[key] = value
+		}
+	}
+	if len(settings) > 0 {
+		template["settings"] = settings
+	}
+	return template
+}
+
+// property of the field in documents, or the field itself if it's not mapped
+func (d *DAO) property(field string) string {
+	if f, ok := d.esFields[field]; ok {
+		return f.property
+	}
+	return field
+}
+
+// field for exact match, prefix and sort: the field itself for keywords and others,
+// or the keyword sub field for texts. Unmapped strings use the keyword sub field of dynamic mapping.
+func (d *DAO) keywordField(field string, value interface{}) string {
+	if f, ok := d.esFields[field]; ok {
+		if f.mapping["type"] != "text" {
+			return f.property
+		}
+		if fields, ok := f.mapping["fields"].(map[string]interface{}); ok && fields["keyword"] != nil {
+			return f.property + ".keyword"
+		}
+		return f.property
+	}
+	if _, ok := value.(string); ok {
+		return field + ".keyword"
+	}
+	return field
+}
+
+// create the index with the template before first use, unless it exists already, e.g. created by Reindex
+func (d *DAO) ensureIndex(ctx context.Context) error {
+	if d.es == nil || d.index == nil {
+		return nil
+	}
+	d.index.lock.Lock()
+	defer d.index.lock.Unlock()
+	if d.index.created {
+		return nil
+	}
+
+	name := d.esIndexName()
+	manager, ok := d.es.(elastic.IndexManager)
+	if !ok {
+		// the index is created by elastic on first write, with dynamic mappings
+		logging.Warnw("elastic client doesn't support index management, index template is not applied", "index", name)
+		d.index.created = true
+		return nil
+	}
+	indexes, err := manager.GetAlias(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(indexes) == 0 {
+		if err = manager.CreateIndex(ctx, name, d.IndexTemplate()); err != nil {
+			// created by another instance in the meantime
+			if indexes, _ = manager.GetAlias(ctx, name); len(indexes) == 0 {
+				return err
+			}
+		} else {
+			logging.Infow("elastic index created", "index", name)
+		}
+	}
+	d.index.created = true
+	return nil
+}
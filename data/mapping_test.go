@@ -0,0 +1,103 @@
+package data_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+type MappingModel struct {
+	data.Model
+	Title    string `es:"text,analyzer=ik_max_word,search_analyzer=ik_smart"`
+	Name     string `es:"text,analyzer=ik,keyword"`
+	UserID   string `es:"keyword"`
+	Nickname string `json:"nick"`
+	Score    float64
+	Active   bool
+	Secret   string `json:"-"`
+}
+
+func (MappingModel) TableName() string {
+	return "mapping"
+}
+
+func (MappingModel) ElasticSettings() map[string]interface{} {
+	return map[string]interface{}{"number_of_shards": 1}
+}
+
+func TestIndexTemplate(t *testing.T) {
+	dbInstance, _ := data.NewMemoryDatabase(nil)
+	dao := data.NewDAO(dbInstance, &MappingModel{})
+	dao.Automigrate()
+	es := &recordingElastic{indexed: map[string]bool{}}
+	dao.SetElasticClient(es)
+
+	expected := `{
+		"settings": {"number_of_shards": 1},
+		"mappings": {"properties": {
+			"ID": {"type": "long"},
+			"CreatedAt": {"type": "date"},
+			"UpdatedAt": {"type": "date"},
+			"DeletedAt": {"type": "date"},
+			"UUID": {"type": "keyword"},
+			"Title": {"type": "text", "analyzer": "ik_max_word", "search_analyzer": "ik_smart"},
+			"Name": {"type": "text", "analyzer": "ik", "fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+			"UserID": {"type": "keyword"},
+			"nick": {"type": "text", "fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+			"Score": {"type": "double"},
+			"Active": {"type": "boolean"}
+		}}
+	}`
+	actual, _ := json.Marshal(dao.IndexTemplate())
+	assert.JSONEq(t, expected, string(actual))
+
+	// the index is created with the template before the first use
+	assert.Nil(t, dao.Create(&MappingModel{Title: "hello", UserID: "user1"}))
+	assert.Eventually(t, func() bool { return es.indexedCount() == 1 }, time.Second, 10*time.Millisecond)
+	actual, _ = json.Marshal(es.created["sqlite_mapping"])
+	assert.JSONEq(t, expected, string(actual))
+
+	// exact match on keywords and keyword sub fields
+	rs := []MappingModel{}
+	dao.Query(data.And(
+		data.Eq("user_id", "user1"),
+		data.Eq("uuid", "1"),
+		data.Prefix("name", "user"),
+		data.Eq("title", "hello"),
+		data.Eq("nickname", "nick"),
+	), &rs)
+	expectedQuery := `{"bool":{"must":{"bool":{"must":[
+		{"bool":{"must":[
+			{"term":{"UserID":"user1"}},
+			{"term":{"UUID":"1"}},
+			{"prefix":{"Name.keyword":"user"}},
+			{"term":{"Title":"hello"}},
+			{"term":{"nick.keyword":"nick"}}
+		]}},
+		{"bool":{"must_not":[{"exists":{"field":"DeletedAt"}}]}}
+	]}}}}`
+	query, _ := json.Marshal(es.searches[0])
+	assert.JSONEq(t, expectedQuery, string(query))
+
+	// other queries use the json property of the field too
+	dao.Query(data.And(
+		data.Between("nickname", "a", "m"),
+		data.Match("nickname", "nick"),
+		data.Eq("nickname", nil),
+		data.NotNull("nickname"),
+	), &rs)
+	expectedQuery = `{"bool":{"must":{"bool":{"must":[
+		{"bool":{"must":[
+			{"bool":{"must":[{"range":{"nick":{"gte":"a"}}},{"range":{"nick":{"lte":"m"}}}]}},
+			{"match":{"nick":"nick"}},
+			{"bool":{"must_not":[{"exists":{"field":"nick"}}]}},
+			{"exists":{"field":"nick"}}
+		]}},
+		{"bool":{"must_not":[{"exists":{"field":"DeletedAt"}}]}}
+	]}}}}`
+	query, _ = json.Marshal(es.searches[1])
+	assert.JSONEq(t, expectedQuery, string(query))
+}
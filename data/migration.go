@@ -0,0 +1,425 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Migration is a versioned schema change. Migrations are applied in the order of versions,
+// e.g. 0001, 0002 or 20220501120000. Numeric versions of different lengths are compared as numbers.
+type Migration struct {
+	Version string
+	Name    string
+	Up      func(tx *gorm.DB) error
+	// optional, the migration can't be reverted without it
+	Down func(tx *gorm.DB) error
+}
+
+// MigrationStatus of a migration known by code or recorded in the database
+type MigrationStatus struct {
+	Version   string
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	// applied in the database, but not defined in code or sql files any more
+	Missing bool
+}
+
+// history of applied migrations
+type schemaMigration struct {
+	Version   string `gorm:"primaryKey;size:64"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// name of the database lock held while migrating, so instances starting together don't apply the same migrations
+const migrationLock = "schema_migrations"
+
+var (
+	// go migrations of each database: [db_key:migrations]
+	migrationRegistry = map[string][]Migration{}
+
+	// <version>_<name>.up.sql or <version>_<name>.down.sql
+	sqlMigrationFile = regexp.MustCompile(`^([0-9A-Za-z]+)_(.+)\.(up|down)\.sql$`)
+)
+
+// RegisterMigrations written in go for the database with dbKey in config, usually in init()
+func RegisterMigrations(dbKey string, migrations ...Migration) {
+	migrationRegistry[dbKey] = append(migrationRegistry[dbKey], migrations...)
+}
+
+// Migrator of the database, with migrations registered by RegisterMigrations and sql files in migrations.dir of config
+func (d *DataManager) Migrator(dbKey string) (*Migrator, error) {
+	db := d.GetDB(dbKey)
+	if db == nil {
+		return nil, errors.New("cannot find database with key " + dbKey)
+	}
+	return d.newMigrator(dbKey, db)
+}
+
+// migrator of db before it's registered with dbKey
+func (d *DataManager) newMigrator(dbKey string, db *Database) (*Migrator, error) {
+	d.lock.RLock()
+	dir := d.migrationDirs[dbKey]
+	d.lock.RUnlock()
+
+	migrations := append([]Migration{}, migrationRegistry[dbKey]...)
+	if dir != "" {
+		sqlMigrations, err := LoadSQLMigrations(dir)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, sqlMigrations...)
+	}
+	return NewMigrator(db, migrations...)
+}
+
+// Migrator applies and reverts migrations of a database, and records them in schema_migrations
+type Migrator struct {
+	db         *Database
+	migrations []Migration
+	// print sql instead of executing it
+	dryRun io.Writer
+}
+
+// NewMigrator for the database. Versions of the migrations must be unique.
+func NewMigrator(db *Database, migrations ...Migration) (*Migrator, error) {
+	sorted := append([]Migration{}, migrations...)
+	sort.SliceStable(sorted, func(i, j int) bool { return versionLess(sorted[i].Version, sorted[j].Version) })
+	for i, m := range sorted {
+		if m.Version == "" || m.Up == nil {
+			return nil, fmt.Errorf("migration %s %s should have version and up", m.Version, m.Name)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicated migration version %s", m.Version)
+		}
+	}
+	return &Migrator{db: db, migrations: sorted}, nil
+}
+
+// LoadSQLMigrations from files named <version>_<name>.up.sql and <version>_<name>.down.sql in dir.
+// Statements in a file are separated by ; at the end of lines.
+func LoadSQLMigrations(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[string]*Migration{}
+	versions := []string{}
+	for _, entry := range entries {
+		matches := sqlMigrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		version, name, direction := matches[1], matches[2], matches[3]
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+			versions = append(versions, version)
+		} else if m.Name != name {
+			return nil, fmt.Errorf("duplicated migration version %s: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = execSQL(string(content))
+		} else {
+			m.Down = execSQL(string(content))
+		}
+	}
+
+	migrations := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		migrations = append(migrations, *byVersion[version])
+	}
+	return migrations, nil
+}
+
+// DryRun returns a migrator printing the sql to w, without changing the database or the history
+func (m *Migrator) DryRun(w io.Writer) *Migrator {
+	dryRun := *m
+	dryRun.dryRun = w
+	return &dryRun
+}
+
+// Status of all migrations, in the order of versions
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &record.AppliedAt
+			delete(applied, migration.Version)
+		}
+		result = append(result, status)
+	}
+	for _, record := range applied {
+		appliedAt := record.AppliedAt
+		result = append(result, MigrationStatus{
+			Version:   record.Version,
+			Name:      record.Name,
+			Applied:   true,
+			AppliedAt: &appliedAt,
+			Missing:   true,
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return versionLess(result[i].Version, result[j].Version) })
+	return result, nil
+}
+
+// Up applies all pending migrations in order, each in a transaction. It stops at the first failure.
+// Mysql and postgres are locked while migrating, so only one instance applies the migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err = m.run(ctx, migration, migration.Up, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down reverts the last steps applied migrations in reverse order, locking the database the same as Up
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == nil {
+			return logging.Errorf("migration %s %s can't be reverted without down", migration.Version, migration.Name)
+		}
+		if err = m.run(ctx, migration, migration.Down, false); err != nil {
+			return err
+		}
+		steps--
+	}
+	return nil
+}
+
+// run the migration and update the history in the same transaction
+func (m *Migrator) run(ctx context.Context, migration Migration, fn func(tx *gorm.DB) error, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+
+	if m.dryRun != nil {
+		fmt.Fprintf(m.dryRun, "-- %s %s %s\n", direction, migration.Version, migration.Name)
+		db := m.db.primary(m.db.WithContext(ctx)).Session(&gorm.Session{DryRun: true, Logger: sqlPrinter{m.dryRun}})
+		return fn(db)
+	}
+
+	start := time.Now()
+	err := m.db.primary(m.db.WithContext(ctx)).Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if up {
+			return tx.Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		}
+		return tx.Delete(&schemaMigration{Version: migration.Version}).Error
+	})
+	if err != nil {
+		return logging.Errorf("migration %s %s %s failed: %s", direction, migration.Version, migration.Name, err.Error())
+	}
+
+	logging.Infow("migration done", "direction", direction, "version", migration.Version, "name", migration.Name,
+		"duration", time.Since(start).String())
+	return nil
+}
+
+// lock the database for migrations until unlock is called, waiting for other instances holding it.
+// The lock belongs to a session, so it's held by a dedicated connection. Nothing is locked for dry run
+// and other databases like sqlite, which are not shared by instances.
+func (m *Migrator) lock(ctx context.Context) (unlock func(), err error) {
+	var lockSQL, unlockSQL string
+	var key interface{}
+	switch m.db.Dialector.Name() {
+	case "mysql":
+		lockSQL, unlockSQL, key = "SELECT GET_LOCK(?, -1)", "SELECT RELEASE_LOCK(?)", migrationLock
+	case "postgres":
+		h := fnv.New64a()
+		h.Write([]byte(migrationLock))
+		lockSQL, unlockSQL, key = "SELECT pg_advisory_lock($1)", "SELECT pg_advisory_unlock($1)", int64(h.Sum64())
+	}
+	if lockSQL == "" || m.dryRun != nil {
+		return func() {}, nil
+	}
+
+	sqlDB, err := m.db.DB.DB()
+	if err != nil {
+		return nil, logging.Errorf("failed to lock for migrations: %s", err.Error())
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, logging.Errorf("failed to lock for migrations: %s", err.Error())
+	}
+	if m.db.Dialector.Name() == "mysql" {
+		// GET_LOCK returns 1 when locked, and NULL on errors
+		var locked sql.NullInt64
+		if err = conn.QueryRowContext(ctx, lockSQL, key).Scan(&locked); err == nil && locked.Int64 != 1 {
+			err = errors.New("lock not granted")
+		}
+	} else {
+		_, err = conn.ExecContext(ctx, lockSQL, key)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, logging.Errorf("failed to lock for migrations: %s", err.Error())
+	}
+
+	return func() {
+		// released even if ctx is done
+		if _, err := conn.ExecContext(context.Background(), unlockSQL, key); err != nil {
+			logging.Errorf("failed to unlock for migrations: %s", err.Error())
+		}
+		conn.Close()
+	}, nil
+}
+
+// applied migrations by version, creating the history table if not exists
+func (m *Migrator) applied(ctx context.Context) (map[string]schemaMigration, error) {
+	db := m.db.primary(m.db.WithContext(ctx))
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		if m.dryRun != nil {
+			return map[string]schemaMigration{}, nil
+		}
+		if err := db.Migrator().CreateTable(&schemaMigration{}); err != nil {
+			return nil, logging.Errorf("failed to create %s: %s", schemaMigration{}.TableName(), err.Error())
+		}
+	}
+
+	records := []schemaMigration{}
+	if err := db.Find(&records).Error; err != nil {
+		return nil, logging.Errorf("failed to read %s: %s", schemaMigration{}.TableName(), err.Error())
+	}
+
+	result := map[string]schemaMigration{}
+	for _, record := range records {
+		result[record.Version] = record
+	}
+	return result, nil
+}
+
+// migration running the statements in content one by one, since not all drivers support multiple statements
+func execSQL(content string) func(tx *gorm.DB) error {
+	statements := []string{}
+	current := strings.Builder{}
+	for _, line := range strings.Split(content, "\n") {
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			statements = append(statements, current.String())
+			current.Reset()
+		}
+	}
+	statements = append(statements, current.String())
+
+	return func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			statement = strings.TrimSpace(statement)
+			if statement == "" || statement == ";" {
+				continue
+			}
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// numeric versions are compared by value, others by string
+func versionLess(a string, b string) bool {
+	if isDigits(a) && isDigits(b) {
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+	}
+	return a < b
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// gorm logger printing the sql of dry run
+type sqlPrinter struct {
+	w io.Writer
+}
+
+func (p sqlPrinter) LogMode(logger.LogLevel) logger.Interface {
+	return p
+}
+
+func (p sqlPrinter) Info(context.Context, string, ...interface{}) {}
+
+func (p sqlPrinter) Warn(context.Context, string, ...interface{}) {}
+
+func (p sqlPrinter) Error(context.Context, string, ...interface{}) {}
+
+func (p sqlPrinter) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	sql, _ := fc()
+	fmt.Fprintf(p.w, "%s;\n", sql)
+}
@@ -0,0 +1,95 @@
+package data_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestMigration(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "0002_add_age.up.sql"), []byte(
+		"ALTER TABLE migration_users ADD COLUMN age integer;\nCREATE INDEX idx_age ON migration_users(age);\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "0002_add_age.down.sql"), []byte("DROP INDEX idx_age;\nALTER TABLE migration_users DROP COLUMN age;"), 0644)
+
+	// a key of its own, since the registry is global
+	data.RegisterMigrations("migrationdb", data.Migration{
+		Version: "0001",
+		Name:    "create_users",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE TABLE migration_users (id integer primary key, name text)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP TABLE migration_users").Error
+		},
+	}, data.Migration{
+		Version: "10",
+		Name:    "seed_users",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("INSERT INTO migration_users (name, age) VALUES (?, ?)", "admin", 30).Error
+		},
+	})
+
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    migrationdb:
+        type: sqlite
+        filepath: migration.db
+        migrations:
+            dir: `+dir+`
+            run: true
+`), "database")
+	defer os.RemoveAll("migration.db")
+	db := manager.GetDB("migrationdb")
+
+	// pending migrations are applied at init, 10 is after 0002
+	var age int
+	db.Raw("SELECT age FROM migration_users WHERE name = ?", "admin").Scan(&age)
+	assert.Equal(t, 30, age)
+
+	migrator, err := manager.Migrator("migrationdb")
+	assert.Nil(t, err)
+	status, err := migrator.Status(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(status))
+	assert.Equal(t, "0002", status[1].Version)
+	assert.True(t, status[2].Applied)
+
+	// 10 has no down
+	assert.NotNil(t, migrator.Down(context.Background(), 1))
+
+	out := &bytes.Buffer{}
+	migrator, _ = data.NewMigrator(db, data.Migration{
+		Version: "0003",
+		Name:    "drop_name",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE migration_users DROP COLUMN name").Error
+		},
+	})
+	assert.Nil(t, migrator.DryRun(out).Up(context.Background()))
+	assert.Equal(t, "-- up 0003 drop_name\nALTER TABLE migration_users DROP COLUMN name;\n", out.String())
+	status, _ = migrator.Status(context.Background())
+	assert.Equal(t, "0003", status[2].Version)
+	assert.False(t, status[2].Applied)
+	assert.True(t, db.Migrator().HasColumn("migration_users", "name"))
+
+	migrator, _ = data.NewMigrator(db, data.Migration{Version: "0001", Up: func(tx *gorm.DB) error { return nil }},
+		data.Migration{Version: "0002", Up: func(tx *gorm.DB) error { return nil }, Down: func(tx *gorm.DB) error { return nil }})
+	// unknown applied versions are reported as missing
+	status, _ = migrator.Status(context.Background())
+	assert.True(t, status[2].Missing)
+	assert.Nil(t, migrator.Down(context.Background(), 1))
+	status, _ = migrator.Status(context.Background())
+	assert.False(t, status[1].Applied)
+
+	_, err = data.NewMigrator(db, data.Migration{Version: "1", Up: func(tx *gorm.DB) error { return nil }},
+		data.Migration{Version: "1", Up: func(tx *gorm.DB) error { return nil }})
+	assert.NotNil(t, err)
+}
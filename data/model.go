@@ -5,6 +5,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// column of PrimaryID() in Model
+const primaryIDColumn = "uuid"
+
 type Model struct {
 	gorm.Model
 	UUID string `gorm:"column:uuid;size:64;not null;uniqueIndex"`
@@ -0,0 +1,394 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// events claimed by a relay are skipped by others until then, so they could be retried if the relay dies
+const outboxClaimTimeout = time.Minute
+
+// the dao of the event is not created yet, e.g. by GetDaoForDb after start. Retried without counting attempts.
+var errDaoNotRegistered = errors.New("dao is not registered")
+
+// OutboxEvent records a change of a record, written in the same transaction as the change.
+// The relay syncs the current state of the record to elastic: indexed if it exists, otherwise deleted.
+type OutboxEvent struct {
+	ID            uint64    `gorm:"primaryKey"`
+	Table         string    `gorm:"column:table_name;size:255;index:idx_cqrs_outbox_record"`
+	RecordID      string    `gorm:"size:64;index:idx_cqrs_outbox_record"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"index"`
+	LastError     string    `gorm:"size:1024"`
+	// set when the event is moved to dead letters after max attempts
+	DeadAt    *time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+func (OutboxEvent) TableName() string {
+	return "cqrs_outbox"
+}
+
+// Outbox syncs records to elastic reliably. Instead of indexing in process after writes, the DAOs write
+// outbox events in the same transaction, and a background relay indexes them with retries.
+// Events of the same record are synced in order, and moved to dead letters after max attempts.
+type Outbox struct {
+	db          *Database
+	dbKey       string
+	manager     *DataManager
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	backoff     backoff
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// outbox of the database, enabled by outbox in the cqrs config:
+//
+//	cqrs:
+//	  type: elastic
+//	  name: elastic1
+//	  outbox:
+//	    interval: 1s          # polling interval of the relay
+//	    batchsize: 100        # events per poll
+//	    maxattempts: 10       # moved to dead letters after
+//	    retryinterval: 1s     # delay before the first retry, doubled after each failure
+//	    retrymaxinterval: 5m  # max delay between retries
+func newOutbox(manager *DataManager, dbKey string, db *Database, conf *config.Config) (*Outbox, error) {
+	interval, err := time.ParseDuration(conf.GetString("interval", "1s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid outbox interval: %w", err)
+	}
+	if err = db.AutoMigrate(&OutboxEvent{}); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", OutboxEvent{}.TableName(), err)
+	}
+
+	b := newBackoff(conf)
+	if conf.GetString("retrymaxinterval") == "" {
+		b.maxInterval = 5 * time.Minute
+	}
+	return &Outbox{
+		db:          db,
+		dbKey:       dbKey,
+		manager:     manager,
+		interval:    interval,
+		batchSize:   conf.GetInt("batchsize", 100),
+		maxAttempts: conf.GetInt("maxattempts", 10),
+		backoff:     b,
+		stop:        make(chan struct{}),
+	}, nil
+}
+
+// Outbox of the database, nil if it's not enabled in config
+func (d *Database) Outbox() *Outbox {
+	return d.outbox
+}
+
+// Start the relay in background
+func (o *Outbox) Start() {
+	go o.run()
+}
+
+// Stop the relay. Pending events are kept, and synced when the relay starts again.
+func (o *Outbox) Stop() {
+	o.stopOnce.Do(func() { close(o.stop) })
+}
+
+func (o *Outbox) run() {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// keep going while batches are full
+		for {
+			done, err := o.Relay(context.Background())
+			if err != nil {
+				logging.Errorf("outbox relay failed for %s: %s", o.dbKey, err.Error())
+				break
+			}
+			if done < o.batchSize {
+				break
+			}
+		}
+	}
+}
+
+// add events of the records in db, which should be the transaction writing them
+func (o *Outbox) enqueue(db *gorm.DB, table string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	events := make([]OutboxEvent, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, OutboxEvent{Table: table, RecordID: id, NextAttemptAt: now})
+	}
+	return db.Session(&gorm.Session{NewDB: true}).CreateInBatches(&events, o.db.BatchSize()).Error
+}
+
+// Relay syncs a batch of due events to elastic, and returns the number of events done.
+// It's called by the background relay, and could also be called directly, e.g. in tests.
+// Relays of multiple instances could run together, each claiming its own events with FOR UPDATE SKIP LOCKED
+// in mysql and postgres. Other databases like sqlite should be written by a single instance.
+func (o *Outbox) Relay(ctx context.Context) (int, error) {
+	db := o.db.primary(o.db.WithContext(ctx))
+	events, err := o.claim(db)
+	if err != nil {
+		return 0, err
+	}
+
+	// events of a record are synced together, since only the current state is indexed
+	type recordKey struct{ table, id string }
+	keys := []recordKey{}
+	groups := map[recordKey][]OutboxEvent{}
+	for _, event := range events {
+		key := recordKey{event.Table, event.RecordID}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], event)
+	}
+
+	done := 0
+	for _, key := range keys {
+		group := groups[key]
+
+		// wait for earlier events of the record, which are waiting for retries
+		var earlier int64
+		err = db.Model(&OutboxEvent{}).
+			Where("table_name = ? AND record_id = ? AND dead_at IS NULL AND id < ?", key.table, key.id, group[0].ID).
+			Count(&earlier).Error
+		if err != nil {
+			return done, err
+		}
+		if earlier > 0 {
+			o.release(ctx, group)
+			continue
+		}
+
+		if err = o.sync(ctx, key.table, key.id); errors.Is(err, errDaoNotRegistered) {
+			logging.Warnw("outbox events waiting for dao", "db", o.dbKey, "table", key.table, "record", key.id)
+			o.release(ctx, group)
+			continue
+		} else if err != nil {
+			o.fail(ctx, group, err)
+			continue
+		}
+		err = db.Where("table_name = ? AND record_id = ? AND dead_at IS NULL AND id <= ?", key.table, key.id, group[len(group)-1].ID).
+			Delete(&OutboxEvent{}).Error
+		if err != nil {
+			return done, err
+		}
+		done += len(group)
+	}
+	return done, nil
+}
+
+// due events of a batch, postponed by outboxClaimTimeout in the same transaction
+func (o *Outbox) claim(db *gorm.DB) ([]OutboxEvent, error) {
+	events := []OutboxEvent{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		query := tx.Where("dead_at IS NULL AND next_attempt_at <= ?", now).Order("id").Limit(o.batchSize)
+		if name := tx.Dialector.Name(); name == "mysql" || name == "postgres" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		if err := query.Find(&events).Error; err != nil || len(events) == 0 {
+			return err
+		}
+		return tx.Model(&OutboxEvent{}).Where("id IN ?", eventIDs(events)).
+			Update("next_attempt_at", now.Add(outboxClaimTimeout)).Error
+	})
+	return events, err
+}
+
+// make the claimed events due again, without counting an attempt
+func (o *Outbox) release(ctx context.Context, events []OutboxEvent) {
+	err := o.db.primary(o.db.WithContext(ctx)).Model(&OutboxEvent{}).Where("id IN ?", eventIDs(events)).
+		Update("next_attempt_at", time.Now()).Error
+	if err != nil {
+		logging.Errorf("failed to update outbox events: %s", err.Error())
+	}
+}
+
+func eventIDs(events []OutboxEvent) []uint64 {
+	ids := make([]uint64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+// index the current state of the record, or delete it from the index if it doesn't exist any more
+func (o *Outbox) sync(ctx context.Context, table string, id string) error {
+	dao := o.manager.findDao(o.dbKey, table)
+	if dao == nil {
+		return fmt.Errorf("%w for %s:%s", errDaoNotRegistered, o.dbKey, table)
+	}
+	if dao.es == nil {
+		return nil
+	}
+	if err := dao.ensureIndex(ctx); err != nil {
+		return err
+	}
+
+	items := reflect.New(reflect.SliceOf(dao.modelType()))
+	err := o.db.primary(o.db.WithContext(ctx).Unscoped()).
+		Where(clause.Eq{Column: clause.Column{Name: dao.idColumn()}, Value: id}).
+		Find(items.Interface()).Error
+	if err != nil {
+		return err
+	}
+	if items.Elem().Len() == 0 {
+		return elastic.DeleteContext(ctx, dao.es, dao.esIndexName(), []string{id})
+	}
+	return elastic.IndexContext(ctx, dao.es, dao.esIndexName(), id, items.Elem().Index(0).Addr().Interface())
+}
+
+// schedule the retry of the events, or move them to dead letters after max attempts
+func (o *Outbox) fail(ctx context.Context, events []OutboxEvent, cause error) {
+	ids := eventIDs(events)
+	attempts := events[0].Attempts + 1
+	message := cause.Error()
+	if len(message) > 1024 {
+		message = message[:1024]
+	}
+
+	updates := map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      message,
+		"next_attempt_at": time.Now().Add(o.backoff.delay(attempts)),
+	}
+	if attempts >= o.maxAttempts {
+		updates["dead_at"] = time.Now()
+		logging.Errorw("outbox events moved to dead letters", "db", o.dbKey, "table", events[0].Table,
+			"record", events[0].RecordID, "attempts", attempts, "error", message)
+	} else {
+		logging.Warnw("outbox sync failed, retrying", "db", o.dbKey, "table", events[0].Table,
+			"record", events[0].RecordID, "attempts", attempts, "error", message)
+	}
+
+	err := o.db.primary(o.db.WithContext(ctx)).Model(&OutboxEvent{}).Where("id IN ?", ids).Updates(updates).Error
+	if err != nil {
+		logging.Errorf("failed to update outbox events: %s", err.Error())
+	}
+}
+
+// Pending number of events not synced yet, excluding dead letters
+func (o *Outbox) Pending(ctx context.Context) (int64, error) {
+	var count int64
+	err := o.db.primary(o.db.WithContext(ctx)).Model(&OutboxEvent{}).Where("dead_at IS NULL").Count(&count).Error
+	return count, err
+}
+
+// DeadLetters are events failed for max attempts, in order
+func (o *Outbox) DeadLetters(ctx context.Context) ([]OutboxEvent, error) {
+	events := []OutboxEvent{}
+	err := o.db.primary(o.db.WithContext(ctx)).Where("dead_at IS NOT NULL").Order("id").Find(&events).Error
+	return events, err
+}
+
+// Retry dead letters by ids, or all of them if no ids, e.g. after elastic is fixed
+func (o *Outbox) Retry(ctx context.Context, ids ...uint64) error {
+	db := o.db.primary(o.db.WithContext(ctx)).Model(&OutboxEvent{}).Where("dead_at IS NOT NULL")
+	if len(ids) > 0 {
+		db = db.Where("id IN ?", ids)
+	}
+	return db.Updates(map[string]interface{}{"dead_at": nil, "attempts": 0, "next_attempt_at": time.Now()}).Error
+}
+
+// run fn in a transaction if the outbox is enabled, so the outbox events are committed with the records.
+// fn runs as is if the dao is bound to a transaction already.
+func (d *DAO) atomically(db *gorm.DB, fn func(db *gorm.DB) error) error {
+	if d.db.outbox == nil || d.tx != nil {
+		return fn(db)
+	}
+	return db.Transaction(fn)
+}
+
+// add outbox events of the records in db, the transaction writing them. No-op if the outbox is not enabled.
+func (d *DAO) enqueue(db *gorm.DB, ids ...string) error {
+	if d.db.outbox == nil {
+		return nil
+	}
+	return d.db.outbox.enqueue(db, d.Name(), ids)
+}
+
+// uuids of the records matching db conditions for the outbox, before they are changed
+func (d *DAO) outboxIDs(db *gorm.DB) ([]string, error) {
+	if d.db.outbox == nil {
+		return nil, nil
+	}
+	return d.matchingIDs(db.Session(&gorm.Session{}).Model(&d.model))
+}
+
+// uuids of the upserted models for the outbox, see storedIDs
+func (d *DAO) upsertedIDs(db *gorm.DB, models []DaoModel, queryColumns []string) ([]string, error) {
+	if d.db.outbox == nil {
+		return nil, nil
+	}
+	return d.storedIDs(db, models, queryColumns)
+}
+
+// uuids of the upserted models in database. Existing records keep their uuids on conflict,
+// so they are found by the query columns, in queries of Database.BatchSize() models within the bind parameter limits.
+func (d *DAO) storedIDs(db *gorm.DB, models []DaoModel, queryColumns []string) ([]string, error) {
+	ids := make([]string, 0, len(models))
+	if len(queryColumns) == 0 {
+		for _, model := range models {
+			ids = append(ids, model.PrimaryID())
+		}
+		return ids, nil
+	}
+
+	size := d.db.BatchSize()
+	for start := 0; start < len(models); start += size {
+		end := start + size
+		if end > len(models) {
+			end = len(models)
+		}
+		batch, err := d.matchingModels(db, models[start:end], queryColumns)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, batch...)
+	}
+	return ids, nil
+}
+
+// uuids of the records with the same values of the query columns as the models
+func (d *DAO) matchingModels(db *gorm.DB, models []DaoModel, queryColumns []string) ([]string, error) {
+	conditions := make([]clause.Expression, 0, len(models))
+	for _, model := range models {
+		v := reflect.Indirect(reflect.ValueOf(model))
+		eqs := make([]clause.Expression, 0, len(queryColumns))
+		for _, column := range queryColumns {
+			field := v.FieldByName(d.columnToField[column])
+			if !field.IsValid() {
+				return nil, fmt.Errorf("unknown column %s in %s", column, d.Name())
+			}
+			eqs = append(eqs, clause.Eq{Column: clause.Column{Name: column}, Value: field.Interface()})
+		}
+		conditions = append(conditions, clause.And(eqs...))
+	}
+	return d.matchingIDs(db.Session(&gorm.Session{NewDB: true}).Model(&d.model).Where(clause.Or(conditions...)))
+}
@@ -0,0 +1,139 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingElastic failing requests when down
+type flakyElastic struct {
+	recordingElastic
+	down bool
+}
+
+func (e *flakyElastic) IndexContext(ctx context.Context, index string, id string, value interface{}) error {
+	if e.down {
+		return errors.New("elastic is down")
+	}
+	return e.recordingElastic.IndexContext(ctx, index, id, value)
+}
+
+func (e *flakyElastic) DeleteContext(ctx context.Context, index string, ids []string) error {
+	if e.down {
+		return errors.New("elastic is down")
+	}
+	return e.recordingElastic.DeleteContext(ctx, index, ids)
+}
+
+func TestOutbox(t *testing.T) {
+	defer os.RemoveAll("outbox.db")
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: outbox.db
+        automigrate: true
+        cqrs:
+            outbox:
+                interval: 1h
+                maxattempts: 2
+                retryinterval: 20ms
+`), "database")
+	ctx := context.Background()
+
+	db := manager.GetDB("db1")
+	es := &flakyElastic{recordingElastic: recordingElastic{indexed: map[string]bool{}}}
+	db.SetElastic(es)
+	dao := manager.GetDaoForDb("db1", &TestModel1{})
+	outbox := db.Outbox()
+	assert.NotNil(t, outbox)
+	defer outbox.Stop()
+
+	// events are written with the records, and indexed by the relay only
+	user1 := &TestModel1{Name: "user1"}
+	assert.Nil(t, dao.Create(user1))
+	err := manager.Transaction("db1", func(tx *data.TxScope) error {
+		users, _ := tx.DAO(&TestModel1{})
+		users.Create(&TestModel1{Name: "user2"})
+		return errors.New("rollback")
+	})
+	assert.NotNil(t, err)
+	pending, err := outbox.Pending(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), pending)
+	assert.Equal(t, 0, es.indexedCount())
+
+	done, err := outbox.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, done)
+	assert.True(t, es.indexed[user1.UUID])
+
+	// the old uuid replaced by update doesn't exist any more, so it's deleted from the index.
+	// The soft deleted record is indexed with DeletedAt.
+	updated := &TestModel1{Name: "user1 updated"}
+	assert.Nil(t, dao.Update(&data.QueryParams{"name": "user1"}, updated))
+	assert.Nil(t, dao.Delete(&data.QueryParams{"name": "user1 updated"}))
+	done, err = outbox.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, done)
+	assert.Equal(t, []string{user1.UUID}, es.deleted)
+	assert.True(t, es.indexed[updated.UUID])
+
+	// later events of a record wait for the earlier ones
+	es.down = true
+	user3 := &TestModel1{Name: "user3"}
+	assert.Nil(t, dao.Create(user3))
+	done, _ = outbox.Relay(ctx)
+	assert.Equal(t, 0, done)
+	assert.Nil(t, dao.Update(&data.QueryParams{"name": "user3"}, &TestModel1{Name: "user3 updated", Model: user3.Model}))
+	done, _ = outbox.Relay(ctx)
+	assert.Equal(t, 0, done)
+	deadLetters, err := outbox.DeadLetters(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(deadLetters))
+
+	// moved to dead letters after max attempts
+	time.Sleep(50 * time.Millisecond)
+	_, err = outbox.Relay(ctx)
+	assert.Nil(t, err)
+	deadLetters, err = outbox.DeadLetters(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(deadLetters))
+	assert.Equal(t, 2, deadLetters[0].Attempts)
+	assert.Equal(t, "elastic is down", deadLetters[0].LastError)
+	pending, _ = outbox.Pending(ctx)
+	assert.Equal(t, int64(0), pending)
+
+	es.down = false
+	assert.Nil(t, outbox.Retry(ctx))
+	done, err = outbox.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, done)
+	assert.True(t, es.indexed[user3.UUID])
+
+	// events of daos not registered in the manager are retried without counting attempts
+	addresses := data.NewDAO(db, &TestModel2{})
+	addresses.Automigrate()
+	assert.Nil(t, addresses.Create(&TestModel2{Name: "address1"}))
+	for i := 0; i < 3; i++ {
+		done, err = outbox.Relay(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, done)
+	}
+	deadLetters, _ = outbox.DeadLetters(ctx)
+	assert.Equal(t, 0, len(deadLetters))
+	pending, _ = outbox.Pending(ctx)
+	assert.Equal(t, int64(1), pending)
+
+	manager.GetDaoForDb("db1", &TestModel2{})
+	done, err = outbox.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, done)
+}
@@ -0,0 +1,276 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/skema-dev/skema-go/logging"
+)
+
+const defaultPageSize = 20
+
+// ErrInvalidCursor is returned when the cursor is malformed or was created with a different order
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// PageOption for cursor based pagination
+type PageOption struct {
+	// page size, 20 by default
+	Size int
+	// sql order like "age desc, name". The primary key is always appended to make the order unique.
+	// Sort columns should be not null.
+	Order string
+	// NextCursor of the previous page, empty for the first page
+	Cursor string
+}
+
+// Page describes where the current page ends
+type Page struct {
+	// opaque token for the next page, empty if there are no more records
+	NextCursor string
+	HasMore    bool
+}
+
+type sortKey struct {
+	column string
+	field  string
+	desc   bool
+}
+
+type cursorToken struct {
+	Order  string            `json:"o"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// QueryPage queries one page of records into result, which must be a pointer to slice of the model.
+// Instead of offset, it continues from the last record of the previous page by keyset in database,
+// or search_after in elasticsearch, so it's fast for deep pages as well.
+func (d *DAO) QueryPage(query interface{}, result interface{}, option PageOption) (*Page, error) {
+	return d.QueryPageContext(context.Background(), query, result, option)
+}
+
+// QueryPageContext is the same as QueryPage, running elastic search and sql with ctx
+func (d *DAO) QueryPageContext(ctx context.Context, query interface{}, result interface{}, option PageOption) (page *Page, err error) {
+	ctx, span := d.startSpan(ctx, "query_page")
+	defer d.observe("query_page", time.Now(), span, &err)
+
+	filter, err := toFilter(query)
+	if err != nil {
+		return nil, logging.Errorf("invalid query for [%s]: %s", d.model.TableName(), err.Error())
+	}
+	keys, err := d.parseSortKeys(option.Order)
+	if err != nil {
+		return nil, logging.Errorf("invalid order for [%s]: %s", d.model.TableName(), err.Error())
+	}
+	var after []interface{}
+	if option.Cursor != "" {
+		if after, err = d.decodeCursor(option.Cursor, option.Order, keys); err != nil {
+			return nil, err
+		}
+	}
+	size := option.Size
+	if size <= 0 {
+		size = defaultPageSize
+	}
+
+	// fetch one more record to know if there is a next page
+	searched, err := d.trySearch(ctx, span, func() error {
+		searchOption := &elastic.SearchOption{Size: size + 1, Sort: d.elasticSort(keys), SearchAfter: elasticSortValues(after)}
+		return d.searchFromElastic(ctx, scopeActive, filter, result, searchOption)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !searched {
+		if after != nil {
+			filter = And(filter, keysetFilter(keys, after))
+		}
+		tx, err := d.where(d.reader(ctx).Model(&d.model), filter)
+		if err != nil {
+			return nil, logging.Errorf("invalid query for [%s]: %s", d.model.TableName(), err.Error())
+		}
+		for _, key := range keys {
+			direction := "asc"
+			if key.desc {
+				direction = "desc"
+			}
+			tx = tx.Order(key.column + " " + direction)
+		}
+		if tx = tx.Limit(size + 1).Find(result); tx.Error != nil {
+			return nil, logging.Errorf("query page failed for [%s]: %s", d.model.TableName(), tx.Error.Error())
+		}
+	}
+
+	items := reflect.ValueOf(result).Elem()
+	page = &Page{HasMore: items.Len() > size}
+	if page.HasMore {
+		items.Set(items.Slice(0, size))
+		if page.NextCursor, err = encodeCursor(option.Order, keys, items.Index(size-1)); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// parse sql order and append the primary key as tie breaker
+func (d *DAO) parseSortKeys(order string) ([]sortKey, error) {
+	keys := []sortKey{}
+	hasPrimaryKey := false
+
+	for _, item := range strings.Split(order, ",") {
+		parts := strings.Fields(item)
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("invalid order %s", item)
+		}
+
+		column, err := d.columnName(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		desc := false
+		if len(parts) == 2 {
+			switch strings.ToLower(parts[1]) {
+			case "asc":
+			case "desc":
+				desc = true
+			default:
+				return nil, fmt.Errorf("invalid order direction %s", parts[1])
+			}
+		}
+
+		keys = append(keys, sortKey{column: column, field: d.columnToField[column], desc: desc})
+		if column == d.primaryKey {
+			hasPrimaryKey = true
+		}
+	}
+
+	if !hasPrimaryKey {
+		field, ok := d.columnToField[d.primaryKey]
+		if !ok {
+			return nil, fmt.Errorf("primary key %s not found", d.primaryKey)
+		}
+		keys = append(keys, sortKey{column: d.primaryKey, field: field})
+	}
+	return keys, nil
+}
+
+// records after the cursor: (a > va) or (a = va and b > vb) or ...
+func keysetFilter(keys []sortKey, values []interface{}) Filter {
+	filters := []Filter{}
+	for i, key := range keys {
+		conditions := []Filter{}
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, Eq(keys[j].column, values[j]))
+		}
+		if key.desc {
+			conditions = append(conditions, Lt(key.column, values[i]))
+		} else {
+			conditions = append(conditions, Gt(key.column, values[i]))
+		}
+		filters = append(filters, And(conditions...))
+	}
+	return Or(filters...)
+}
+
+// sort on the keyword sub field for texts
+func (d *DAO) elasticSort(keys []sortKey) string {
+	sorts := []string{}
+	for _, key := range keys {
+		field := d.sortField(key.field)
+		direction := "asc"
+		if key.desc {
+			direction = "desc"
+		}
+		sorts = append(sorts, field+" "+direction)
+	}
+	return strings.Join(sorts, ",")
+}
+
+// document field to sort and aggregate the model field by
+func (d *DAO) sortField(field string) string {
+	var value interface{}
+	if f, ok := d.modelType().FieldByName(field); ok && f.Type.Kind() == reflect.String {
+		value = ""
+	}
+	return d.keywordField(field, value)
+}
+
+// elasticsearch uses epoch milliseconds as sort values of dates
+func elasticSortValues(values []interface{}) []interface{} {
+	if values == nil {
+		return nil
+	}
+
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		switch t := v.(type) {
+		case time.Time:
+			result[i] = t.UnixMilli()
+		case *time.Time:
+			if t != nil {
+				result[i] = t.UnixMilli()
+			}
+		default:
+			result[i] = v
+		}
+	}
+	return result
+}
+
+// cursor is built from the sort values of the last record, so it works for both database and elasticsearch
+func encodeCursor(order string, keys []sortKey, last reflect.Value) (string, error) {
+	token := cursorToken{Order: order}
+	for _, key := range keys {
+		value, err := json.Marshal(reflect.Indirect(last).FieldByName(key.field).Interface())
+		if err != nil {
+			return "", logging.Errorf("failed to encode cursor: %s", err.Error())
+		}
+		token.Values = append(token.Values, value)
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", logging.Errorf("failed to encode cursor: %s", err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decode the values into the types of the model fields
+func (d *DAO) decodeCursor(cursor string, order string, keys []sortKey) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	token := cursorToken{}
+	if err = json.Unmarshal(data, &token); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if token.Order != order || len(token.Values) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+
+	modelType := d.modelType()
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		field, ok := modelType.FieldByName(key.field)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		value := reflect.New(field.Type)
+		if err = json.Unmarshal(token.Values[i], value.Interface()); err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = value.Elem().Interface()
+	}
+	return values, nil
+}
@@ -0,0 +1,95 @@
+package data_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+type PageModel struct {
+	data.Model
+	Name string
+	Age  int
+}
+
+func (PageModel) TableName() string {
+	return "page"
+}
+
+func TestQueryPage(t *testing.T) {
+	dbInstance, _ := data.NewSqliteDatabase(config.NewConfigWithString(`filepath: page.db`))
+	defer os.RemoveAll("page.db")
+	items := data.NewTypedDAO[PageModel](dbInstance)
+	items.Untyped().Automigrate()
+
+	for i := 0; i < 7; i++ {
+		// duplicated ages, so the primary key is required to break ties
+		items.Create(&PageModel{Name: fmt.Sprintf("user%d", i), Age: 20 + i/2})
+	}
+
+	names := []string{}
+	option := data.PageOption{Size: 3, Order: "age desc"}
+	for {
+		rs, page, err := items.ListPage(data.Lt("age", 23), option)
+		assert.Nil(t, err)
+		for _, r := range rs {
+			names = append(names, r.Name)
+		}
+		if !page.HasMore {
+			assert.Equal(t, "", page.NextCursor)
+			break
+		}
+		option.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"user4", "user5", "user2", "user3", "user0", "user1"}, names)
+
+	_, _, err := items.ListPage(nil, data.PageOption{Size: 3, Order: "name", Cursor: option.Cursor})
+	assert.ErrorIs(t, err, data.ErrInvalidCursor)
+	_, _, err = items.ListPage(nil, data.PageOption{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, data.ErrInvalidCursor)
+	_, _, err = items.ListPage(nil, data.PageOption{Order: "age up"})
+	assert.NotNil(t, err)
+}
+
+func TestQueryPageElastic(t *testing.T) {
+	dbInstance, _ := data.NewMemoryDatabase(nil)
+	dao := data.NewDAO(dbInstance, &PageModel{})
+	dao.Automigrate()
+	// set before creating, so the async indexing doesn't race with it
+	es := &recordingElastic{indexed: map[string]bool{}}
+	dao.SetElasticClient(es)
+	for i := 0; i < 3; i++ {
+		dao.Create(&PageModel{Name: fmt.Sprintf("user%d", i), Age: 20})
+	}
+
+	// search fails in recordingElastic and falls back to database
+	rs := []PageModel{}
+	page, err := dao.QueryPage(nil, &rs, data.PageOption{Size: 2, Order: "name desc"})
+	assert.Nil(t, err)
+	assert.True(t, page.HasMore)
+
+	rs = []PageModel{}
+	dao.QueryPage(nil, &rs, data.PageOption{Size: 2, Order: "name desc", Cursor: page.NextCursor})
+	search, _ := json.Marshal(es.searches[1])
+	assert.Contains(t, string(search), `{"bool":{"must":{"bool":{"must":[{"match_all":{}},{"bool":{"must_not":[{"exists":{"field":"DeletedAt"}}]}}]}}}}`)
+	assert.Equal(t, 1, len(rs))
+	assert.Equal(t, "user0", rs[0].Name)
+	assert.Equal(t, "Name.keyword desc,ID asc", es.options[1].Sort)
+	assert.Equal(t, 3, es.options[1].Size)
+	assert.Equal(t, []interface{}{"user1", uint(2)}, es.options[1].SearchAfter)
+
+	// pointers of the model are encoded the same
+	pointers := []*PageModel{}
+	page, err = dao.QueryPage(nil, &pointers, data.PageOption{Size: 2, Order: "name desc"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pointers))
+	pointers = []*PageModel{}
+	_, err = dao.QueryPage(nil, &pointers, data.PageOption{Size: 2, Order: "name desc", Cursor: page.NextCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, "user0", pointers[0].Name)
+}
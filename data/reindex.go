@@ -0,0 +1,394 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm/clause"
+)
+
+// DriftReport compares the records of a table with the documents in its elastic index
+type DriftReport struct {
+	Table     string
+	Index     string
+	Rows      int64
+	Documents int64
+	// uuids of records not indexed
+	Missing []string
+	// uuids of documents different from the records
+	Stale []string
+	// uuids of documents without records
+	Orphaned []string
+}
+
+// InSync is true if the index has exactly the records of the table
+func (r *DriftReport) InSync() bool {
+	return len(r.Missing) == 0 && len(r.Stale) == 0 && len(r.Orphaned) == 0
+}
+
+func (r *DriftReport) String() string {
+	return fmt.Sprintf("%s -> %s: %d rows, %d documents, %d missing, %d stale, %d orphaned",
+		r.Table, r.Index, r.Rows, r.Documents, len(r.Missing), len(r.Stale), len(r.Orphaned))
+}
+
+// Backfill indexes all records of the table in bulk, e.g. after enabling elastic for an existing table.
+// It returns the number of records indexed.
+func (d *DAO) Backfill() (int64, error) {
+	return d.BackfillContext(context.Background())
+}
+
+// BackfillContext is the same as Backfill, running sql and elastic requests with ctx
+func (d *DAO) BackfillContext(ctx context.Context) (count int64, err error) {
+	ctx, span := d.startSpan(ctx, "backfill")
+	defer d.observe("backfill", time.Now(), span, &err)
+
+	if err = d.requireElastic(); err != nil {
+		return 0, err
+	}
+	if err = d.ensureIndex(ctx); err != nil {
+		return 0, err
+	}
+	return d.backfill(ctx, d.esIndexName())
+}
+
+// Reconcile compares the records with the documents in the index by uuids and checksums of their json,
+// without changing anything
+func (d *DAO) Reconcile() (*DriftReport, error) {
+	return d.ReconcileContext(context.Background())
+}
+
+// ReconcileContext is the same as Reconcile, running sql and elastic requests with ctx
+func (d *DAO) ReconcileContext(ctx context.Context) (report *DriftReport, err error) {
+	ctx, span := d.startSpan(ctx, "reconcile")
+	defer d.observe("reconcile", time.Now(), span, &err)
+
+	if err = d.requireElastic(); err != nil {
+		return nil, err
+	}
+	return d.reconcile(ctx)
+}
+
+// Repair reconciles the index and fixes the drift: missing and stale documents are indexed again,
+// orphaned ones are deleted. It returns the drift found before repairing.
+func (d *DAO) Repair() (*DriftReport, error) {
+	return d.RepairContext(context.Background())
+}
+
+// RepairContext is the same as Repair, running sql and elastic requests with ctx
+func (d *DAO) RepairContext(ctx context.Context) (report *DriftReport, err error) {
+	ctx, span := d.startSpan(ctx, "repair")
+	defer d.observe("repair", time.Now(), span, &err)
+
+	if err = d.requireElastic(); err != nil {
+		return nil, err
+	}
+	if err = d.ensureIndex(ctx); err != nil {
+		return nil, err
+	}
+	report, err = d.reconcile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := append(append(append([]string{}, report.Missing...), report.Stale...), report.Orphaned...)
+	if err = d.syncRecords(ctx, d.esIndexName(), ids); err != nil {
+		return report, err
+	}
+	if !report.InSync() {
+		logging.Infow("elastic index repaired", "report", report.String())
+	}
+	return report, nil
+}
+
+// Reindex builds a new index with all records and points the index name to it as an alias, so searches
+// keep working while reindexing. Records written during the reindex are repaired after the swap,
+// and the old index is deleted.
+func (d *DAO) Reindex() error {
+	return d.ReindexContext(context.Background())
+}
+
+// ReindexContext is the same as Reindex, running sql and elastic requests with ctx
+func (d *DAO) ReindexContext(ctx context.Context) (err error) {
+	ctx, span := d.startSpan(ctx, "reindex")
+	defer d.observe("reindex", time.Now(), span, &err)
+
+	if err = d.requireElastic(); err != nil {
+		return err
+	}
+	manager, err := d.indexManager()
+	if err != nil {
+		return err
+	}
+
+	alias := d.esIndexName()
+	index := fmt.Sprintf("%s_%s", alias, time.Now().UTC().Format("20060102150405"))
+	if err = manager.CreateIndex(ctx, index, d.IndexTemplate()); err != nil {
+		return err
+	}
+	swapped := false
+	defer func() {
+		// the new index is in use once swapped, so it's kept even if repairing fails
+		if err != nil && !swapped {
+			d.es.DeleteIndex([]string{index})
+		}
+	}()
+	if _, err = d.backfill(ctx, index); err != nil {
+		return err
+	}
+
+	current, err := manager.GetAlias(ctx, alias)
+	if err != nil {
+		return err
+	}
+	if err = manager.SwapAlias(ctx, alias, index); err != nil {
+		return err
+	}
+	swapped = true
+	logging.Infow("elastic index swapped", "alias", alias, "index", index, "previous", current)
+	d.index.lock.Lock()
+	d.index.created = true
+	d.index.lock.Unlock()
+
+	// records written to the previous index while backfilling the new one
+	if _, err = d.RepairContext(ctx); err != nil {
+		return err
+	}
+
+	// an index with the alias name is deleted by the swap already
+	previous := []string{}
+	for _, c := range current {
+		if c != alias && c != index {
+			previous = append(previous, c)
+		}
+	}
+	if len(previous) > 0 {
+		d.es.DeleteIndex(previous)
+	}
+	return nil
+}
+
+func (d *DAO) requireElastic() error {
+	if d.es == nil {
+		return logging.Errorf("elastic is not enabled for [%s]", d.model.TableName())
+	}
+	return nil
+}
+
+// the client as an IndexManager, required to reindex and reconcile
+func (d *DAO) indexManager() (elastic.IndexManager, error) {
+	if manager, ok := d.es.(elastic.IndexManager); ok {
+		return manager, nil
+	}
+	return nil, fmt.Errorf("index management for [%s]: %w", d.model.TableName(), elastic.ErrNotSupported)
+}
+
+// index all records into index in bulk
+func (d *DAO) backfill(ctx context.Context, index string) (int64, error) {
+	var count int64
+	err := d.scanRecords(ctx, func(models []DaoModel) error {
+		if err := elastic.BulkIndexContext(ctx, d.es, index, toDocuments(models)); err != nil {
+			return err
+		}
+		count += int64(len(models))
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	logging.Infow("elastic index backfilled", "table", d.model.TableName(), "index", index, "count", count)
+	return count, nil
+}
+
+func (d *DAO) reconcile(ctx context.Context) (*DriftReport, error) {
+	manager, err := d.indexManager()
+	if err != nil {
+		return nil, err
+	}
+	report := &DriftReport{Table: d.model.TableName(), Index: d.esIndexName()}
+
+	checksums := map[string]string{}
+	err = manager.Scan(ctx, report.Index, d.db.BatchSize(), func(docs []elastic.Document) error {
+		for _, doc := range docs {
+			sum, err := checksum(doc.Value)
+			if err != nil {
+				return err
+			}
+			checksums[doc.ID] = sum
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Documents = int64(len(checksums))
+
+	err = d.scanRecords(ctx, func(models []DaoModel) error {
+		report.Rows += int64(len(models))
+		for _, model := range models {
+			id := model.PrimaryID()
+			indexed, ok := checksums[id]
+			if !ok {
+				report.Missing = append(report.Missing, id)
+				continue
+			}
+			delete(checksums, id)
+
+			sum, err := checksum(model)
+			if err != nil {
+				return err
+			}
+			if sum != indexed {
+				report.Stale = append(report.Stale, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for id := range checksums {
+		report.Orphaned = append(report.Orphaned, id)
+	}
+	sort.Strings(report.Orphaned)
+	return report, nil
+}
+
+// index the current state of the records by uuids, and delete the ones not existing any more
+func (d *DAO) syncRecords(ctx context.Context, index string, ids []string) error {
+	size := d.db.BatchSize()
+	for start := 0; start < len(ids); start += size {
+		end := start + size
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		values := make([]interface{}, 0, end-start)
+		for _, id := range ids[start:end] {
+			values = append(values, id)
+		}
+		items := reflect.New(reflect.SliceOf(d.modelType()))
+		err := d.db.primary(d.db.WithContext(ctx).Unscoped()).
+			Where(clause.IN{Column: clause.Column{Name: d.idColumn()}, Values: values}).
+			Find(items.Interface()).Error
+		if err != nil {
+			return err
+		}
+
+		models := toModels(items.Elem())
+		found := map[string]bool{}
+		for _, model := range models {
+			found[model.PrimaryID()] = true
+		}
+		deleted := []string{}
+		for _, id := range ids[start:end] {
+			if !found[id] {
+				deleted = append(deleted, id)
+			}
+		}
+
+		if err = elastic.BulkIndexContext(ctx, d.es, index, toDocuments(models)); err != nil {
+			return err
+		}
+		if len(deleted) > 0 {
+			if err = elastic.DeleteContext(ctx, d.es, index, deleted); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// load all records including soft deleted ones in batches ordered by the id column, and call fn with each batch
+func (d *DAO) scanRecords(ctx context.Context, fn func(models []DaoModel) error) error {
+	size := d.db.BatchSize()
+	column := d.idColumn()
+	// value of the id column in the last record, in the type of the field, e.g. numeric primary keys
+	var last interface{}
+	for {
+		items := reflect.New(reflect.SliceOf(d.modelType()))
+		db := d.db.primary(d.db.WithContext(ctx).Unscoped())
+		if last != nil {
+			db = db.Where(clause.Gt{Column: clause.Column{Name: column}, Value: last})
+		}
+		err := db.Order(clause.OrderByColumn{Column: clause.Column{Name: column}}).
+			Limit(size).
+			Find(items.Interface()).Error
+		if err != nil {
+			return err
+		}
+
+		models := toModels(items.Elem())
+		if len(models) == 0 {
+			return nil
+		}
+		if err = fn(models); err != nil {
+			return err
+		}
+		if len(models) < size {
+			return nil
+		}
+		last = items.Elem().Index(len(models) - 1).FieldByName(d.columnToField[column]).Interface()
+	}
+}
+
+func toModels(items reflect.Value) []DaoModel {
+	models := make([]DaoModel, 0, items.Len())
+	for i := 0; i < items.Len(); i++ {
+		models = append(models, items.Index(i).Addr().Interface().(DaoModel))
+	}
+	return models
+}
+
+func toDocuments(models []DaoModel) []elastic.Document {
+	docs := make([]elastic.Document, 0, len(models))
+	for _, model := range models {
+		docs = append(docs, elastic.Document{ID: model.PrimaryID(), Value: model})
+	}
+	return docs
+}
+
+// checksum of the json of a record or a document source. Keys are sorted by decoding the json into maps,
+// and times are compared in UTC truncated to milliseconds.
+func checksum(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	var decoded interface{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		return "", err
+	}
+	if data, err = json.Marshal(normalizeJSON(decoded)); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func normalizeJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeJSON(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeJSON(item)
+		}
+	case string:
+		// databases keep micro or milliseconds while documents indexed from models keep nanoseconds
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t.UTC().Truncate(time.Millisecond).Format(time.RFC3339Nano)
+		}
+	}
+	return value
+}
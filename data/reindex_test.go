@@ -0,0 +1,258 @@
+package data_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// memoryElastic keeps documents as decoded json like elastic, with aliases
+type memoryElastic struct {
+	recordingElastic
+	mux     sync.Mutex
+	indexes map[string]map[string]map[string]interface{}
+	aliases map[string]string
+	// returned by SwapAlias if set
+	swapErr error
+}
+
+func newMemoryElastic() *memoryElastic {
+	return &memoryElastic{
+		recordingElastic: recordingElastic{indexed: map[string]bool{}},
+		indexes:          map[string]map[string]map[string]interface{}{},
+		aliases:          map[string]string{},
+	}
+}
+
+func (e *memoryElastic) resolve(index string) string {
+	if target, ok := e.aliases[index]; ok {
+		return target
+	}
+	return index
+}
+
+func (e *memoryElastic) IndexContext(ctx context.Context, index string, id string, value interface{}) error {
+	return e.BulkIndexContext(ctx, index, []elastic.Document{{ID: id, Value: value}})
+}
+
+func (e *memoryElastic) BulkIndexContext(ctx context.Context, index string, docs []elastic.Document) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	index = e.resolve(index)
+	if e.indexes[index] == nil {
+		e.indexes[index] = map[string]map[string]interface{}{}
+	}
+	for _, doc := range docs {
+		body, _ := json.Marshal(doc.Value)
+		source := map[string]interface{}{}
+		json.Unmarshal(body, &source)
+		e.indexes[index][doc.ID] = source
+	}
+	return nil
+}
+
+func (e *memoryElastic) DeleteContext(ctx context.Context, index string, ids []string) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	for _, id := range ids {
+		delete(e.indexes[e.resolve(index)], id)
+	}
+	return nil
+}
+
+func (e *memoryElastic) DeleteIndex(indexes []string) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	for _, index := range indexes {
+		delete(e.indexes, index)
+	}
+}
+
+func (e *memoryElastic) CreateIndex(ctx context.Context, index string, body map[string]interface{}) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	e.indexes[index] = map[string]map[string]interface{}{}
+	return nil
+}
+
+func (e *memoryElastic) Scan(ctx context.Context, index string, size int, fn func(docs []elastic.Document) error) error {
+	e.mux.Lock()
+	docs := []elastic.Document{}
+	for id, source := range e.indexes[e.resolve(index)] {
+		docs = append(docs, elastic.Document{ID: id, Value: source})
+	}
+	e.mux.Unlock()
+
+	for start := 0; start < len(docs); start += size {
+		end := start + size
+		if end > len(docs) {
+			end = len(docs)
+		}
+		if err := fn(docs[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *memoryElastic) GetAlias(ctx context.Context, alias string) ([]string, error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	if target, ok := e.aliases[alias]; ok {
+		return []string{target}, nil
+	}
+	if _, ok := e.indexes[alias]; ok {
+		return []string{alias}, nil
+	}
+	return nil, nil
+}
+
+func (e *memoryElastic) SwapAlias(ctx context.Context, alias string, index string) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	if e.swapErr != nil {
+		return e.swapErr
+	}
+	delete(e.indexes, alias)
+	e.aliases[alias] = index
+	return nil
+}
+
+func (e *memoryElastic) ids(index string) []string {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	ids := []string{}
+	for id := range e.indexes[e.resolve(index)] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestReindex(t *testing.T) {
+	defer os.RemoveAll("reindex.db")
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: reindex.db
+        automigrate: true
+        batchsize: 2
+`), "database")
+	ctx := context.Background()
+
+	// records written before elastic is enabled
+	plain := manager.GetDaoForDb("db1", &TestModel1{})
+	users := []*TestModel1{{Name: "user1"}, {Name: "user2"}, {Name: "user3"}}
+	assert.Nil(t, plain.CreateBatch(users))
+	_, err := plain.Reconcile()
+	assert.NotNil(t, err)
+
+	es := newMemoryElastic()
+	manager = data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: reindex.db
+        batchsize: 2
+`), "database")
+	manager.GetDB("db1").SetElastic(es)
+	dao := manager.GetDaoForDb("db1", &TestModel1{})
+	index := "sqlite_test1"
+
+	report, err := dao.ReconcileContext(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), report.Rows)
+	assert.Equal(t, 3, len(report.Missing))
+
+	count, err := dao.BackfillContext(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+	report, err = dao.ReconcileContext(ctx)
+	assert.Nil(t, err)
+	assert.True(t, report.InSync())
+	assert.Equal(t, int64(3), report.Documents)
+
+	// drift: a stale document, an orphaned one and a missing one
+	es.IndexContext(ctx, index, users[0].UUID, map[string]interface{}{"Name": "outdated"})
+	es.IndexContext(ctx, index, "orphan", map[string]interface{}{"Name": "orphan"})
+	es.DeleteContext(ctx, index, []string{users[1].UUID})
+
+	var out bytes.Buffer
+	err = manager.RunIndexCommand(ctx, []string{"check", "-db", "db1"}, &out)
+	assert.NotNil(t, err)
+	assert.Contains(t, out.String(), "test1 -> sqlite_test1: 3 rows, 3 documents, 1 missing, 1 stale, 1 orphaned")
+
+	report, err = dao.RepairContext(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{users[1].UUID}, report.Missing)
+	assert.Equal(t, []string{users[0].UUID}, report.Stale)
+	assert.Equal(t, []string{"orphan"}, report.Orphaned)
+	report, err = dao.ReconcileContext(ctx)
+	assert.Nil(t, err)
+	assert.True(t, report.InSync())
+
+	// the index created before is replaced by an alias to the new index
+	out.Reset()
+	assert.Nil(t, manager.RunIndexCommand(ctx, []string{"reindex", "-tables", "test1"}, &out))
+	indexes, _ := es.GetAlias(ctx, index)
+	assert.Equal(t, 1, len(indexes))
+	assert.NotEqual(t, index, indexes[0])
+	assert.Equal(t, 3, len(es.ids(index)))
+	assert.Nil(t, manager.RunIndexCommand(ctx, []string{"check"}, &out))
+
+	assert.NotNil(t, manager.RunIndexCommand(ctx, []string{"check", "-tables", "unknown"}, &out))
+	assert.NotNil(t, manager.RunIndexCommand(ctx, []string{"drop"}, &out))
+
+	// the new index is deleted if it can't be swapped
+	failing := newMemoryElastic()
+	failing.swapErr = errors.New("swap failed")
+	dao = data.NewDAO(manager.GetDB("db1"), &TestModel1{})
+	dao.SetElasticClient(failing)
+	assert.NotNil(t, dao.ReindexContext(ctx))
+	assert.Equal(t, 0, len(failing.indexes))
+
+	// clients without index management index records without creating the index, but can't reindex
+	unmanaged := newMemoryElastic()
+	dao = data.NewDAO(manager.GetDB("db1"), &TestModel1{})
+	dao.SetElasticClient(struct{ elastic.Elastic }{unmanaged})
+	user := &TestModel1{Name: "user4"}
+	assert.Nil(t, dao.CreateContext(ctx, user))
+	assert.Eventually(t, func() bool { return unmanaged.indexedCount() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, len(unmanaged.indexes))
+	_, err = dao.ReconcileContext(ctx)
+	assert.True(t, errors.Is(err, elastic.ErrNotSupported))
+	assert.True(t, errors.Is(dao.ReindexContext(ctx), elastic.ErrNotSupported))
+}
+
+func TestChecksumTimes(t *testing.T) {
+	sum := func(createdAt time.Time) string {
+		value, err := data.Checksum(&TestModel1{Model: data.Model{Model: gorm.Model{CreatedAt: createdAt}}, Name: "user1"})
+		assert.Nil(t, err)
+		return value
+	}
+	now := time.Date(2022, 5, 1, 10, 30, 0, 123456789, time.FixedZone("CST", 8*3600))
+
+	// the time read back from a database keeping microseconds, in UTC
+	assert.Equal(t, sum(now), sum(now.Truncate(time.Microsecond).UTC()))
+	assert.NotEqual(t, sum(now), sum(now.Add(time.Millisecond)))
+}
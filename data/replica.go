@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// config values to build a dsn, either *config.Config or replicaConfig
+type dsnConfig interface {
+	GetString(key string, opts ...string) string
+	GetInt(key string, opts ...int) int
+	GetStringMap(key string) map[string]string
+}
+
+// keys of the replica itself, which are never taken from the primary
+var replicaOnlyKeys = map[string]bool{"dsn": true, "socket": true}
+
+// replica config falling back to the primary, so only the differences need to be defined
+type replicaConfig struct {
+	replica *config.Config
+	primary *config.Config
+}
+
+func (c replicaConfig) GetString(key string, opts ...string) string {
+	if replicaOnlyKeys[key] {
+		return c.replica.GetString(key, opts...)
+	}
+	return c.replica.GetString(key, c.primary.GetString(key, opts...))
+}
+
+func (c replicaConfig) GetInt(key string, opts ...int) int {
+	return c.replica.GetInt(key, c.primary.GetInt(key, opts...))
+}
+
+// maps are merged, with values of the replica overriding the primary
+func (c replicaConfig) GetStringMap(key string) map[string]string {
+	result := c.primary.GetStringMap(key)
+	for k, v := range c.replica.GetStringMap(key) {
+		result[k] = v
+	}
+	return result
+}
+
+// picks replicas in turn
+type roundRobinPolicy struct {
+	next uint64
+}
+
+func (p *roundRobinPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	i := atomic.AddUint64(&p.next, 1) - 1
+	return connPools[i%uint64(len(connPools))]
+}
+
+func newReplicaPolicy(name string) (dbresolver.Policy, error) {
+	switch name {
+	case "", "random":
+		return dbresolver.RandomPolicy{}, nil
+	case "roundrobin":
+		return &roundRobinPolicy{}, nil
+	}
+	return nil, fmt.Errorf("unsupported replica policy %s", name)
+}
+
+// route reads to the replicas defined in config. Writes, transactions and locking reads stay on the primary.
+//
+//	replicas:
+//	  - replica1:
+//	      host: 10.0.0.2
+//	  - replica2:
+//	      host: 10.0.0.3
+//	      port: 3307
+//	policy: roundrobin # random by default
+func useReplicas(db *gorm.DB, conf *config.Config, dialector func(conf dsnConfig) (gorm.Dialector, error)) (_ *dbresolver.DBResolver, _ []replica, err error) {
+	items := conf.GetArrayItems("replicas")
+	if len(items) == 0 {
+		return nil, nil, nil
+	}
+
+	replicas := []replica{}
+	defer func() {
+		// replicas opened before the failure
+		if err != nil {
+			closeConnections(nil, replicas)
+		}
+	}()
+	dialectors := []gorm.Dialector{}
+	for _, item := range items {
+		if item.Config == nil {
+			return nil, nil, fmt.Errorf("replica %s is not defined", item.Key)
+		}
+		replicaDialector, err := dialector(replicaConfig{replica: item.Config, primary: conf})
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid replica %s: %w", item.Key, err)
+		}
+		replicaDB, err := gorm.Open(replicaDialector, newGormConfig(conf))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect replica %s: %w", item.Key, err)
+		}
+		sqlDB, err := replicaDB.DB()
+		if err != nil {
+			return nil, nil, err
+		}
+		replicas = append(replicas, replica{name: item.Key, db: sqlDB})
+		dialectors = append(dialectors, openedDialector{Dialector: replicaDB.Dialector, pool: sqlDB})
+	}
+	policy, err := newReplicaPolicy(conf.GetString("policy", "random"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolver := dbresolver.Register(dbresolver.Config{Replicas: dialectors, Policy: policy})
+	if err = db.Use(resolver); err != nil {
+		return nil, nil, fmt.Errorf("failed to connect replicas: %w", err)
+	}
+	logging.Infow("read replicas enabled", "replicas", len(replicas), "policy", conf.GetString("policy", "random"))
+	return resolver, replicas, nil
+}
+
+// a replica connection, opened by us so its pool could be configured and observed
+type replica struct {
+	name string
+	db   *sql.DB
+}
+
+// dialector handing the opened replica connection to dbresolver
+type openedDialector struct {
+	gorm.Dialector
+	pool *sql.DB
+}
+
+func (d openedDialector) Initialize(db *gorm.DB) error {
+	db.ConnPool = d.pool
+	return nil
+}
+
+type primaryContextKey struct{}
+
+// WithPrimary makes the DAO queries with the returned context read from the primary database instead of replicas and elastic,
+// for read-after-write consistency, e.g. dao.QueryContext(data.WithPrimary(ctx), query, &result)
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryContextKey{}).(bool)
+	return v
+}
+
+// read from the primary, ignored if there are no replicas
+func (d *Database) primary(db *gorm.DB) *gorm.DB {
+	if d.resolver == nil {
+		return db
+	}
+	return db.Clauses(dbresolver.Write)
+}
+
+// AutoMigrate on the primary, otherwise tables are looked up in replicas by dbresolver
+func (d *Database) AutoMigrate(dst ...interface{}) error {
+	return d.primary(&d.DB).AutoMigrate(dst...)
+}
+
+// db for queries of ctx, on a replica unless WithPrimary
+func (d *Database) reader(ctx context.Context) *gorm.DB {
+	db := d.WithContext(ctx)
+	if usePrimary(ctx) {
+		return d.primary(db)
+	}
+	return db
+}
@@ -0,0 +1,74 @@
+package data_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+type ReplicaModel struct {
+	data.Model
+	Name string
+}
+
+func (ReplicaModel) TableName() string {
+	return "replica"
+}
+
+func TestReadReplicas(t *testing.T) {
+	defer os.RemoveAll("primary.db")
+	defer os.RemoveAll("replica.db")
+
+	// the replica is a separate file here, so it's visible where the sql goes
+	replica, err := data.NewSqliteDatabase(config.NewConfigWithString(`filepath: replica.db`))
+	assert.Nil(t, err)
+	replicaDao := data.NewDAO(replica, &ReplicaModel{})
+	replicaDao.Automigrate()
+	assert.Nil(t, replicaDao.Create(&ReplicaModel{Name: "on replica"}))
+
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: primary.db
+        automigrate: true
+        policy: roundrobin
+        replicas:
+          - replica1:
+              filepath: replica.db
+`), "database")
+	dao := manager.GetDaoForDb("db1", &ReplicaModel{})
+
+	// writes go to the primary, reads to the replica
+	assert.Nil(t, dao.Create(&ReplicaModel{Name: "on primary"}))
+	rs := []ReplicaModel{}
+	assert.Nil(t, dao.Query(nil, &rs))
+	assert.Equal(t, 1, len(rs))
+	assert.Equal(t, "on replica", rs[0].Name)
+
+	rs = []ReplicaModel{}
+	assert.Nil(t, dao.QueryContext(data.WithPrimary(context.Background()), nil, &rs))
+	assert.Equal(t, 1, len(rs))
+	assert.Equal(t, "on primary", rs[0].Name)
+	count, _ := dao.CountContext(data.WithPrimary(context.Background()), &data.QueryParams{"name": "on primary"})
+	assert.Equal(t, int64(1), count)
+
+	// delete finds the records on the primary
+	assert.Nil(t, dao.Delete(&data.QueryParams{"name": "on primary"}))
+
+	// transactions stay on the primary
+	err = manager.Transaction("db1", func(tx *data.TxScope) error {
+		rs := []ReplicaModel{}
+		dao, err := tx.DAO(&ReplicaModel{})
+		assert.Nil(t, err)
+		dao.QueryWithDeleted(nil, &rs)
+		assert.Equal(t, 1, len(rs))
+		assert.Equal(t, "on primary", rs[0].Name)
+		return nil
+	})
+	assert.Nil(t, err)
+}
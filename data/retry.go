@@ -0,0 +1,140 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm"
+)
+
+// ErrDatabaseUnavailable is returned for databases not connected yet in degraded mode
+var ErrDatabaseUnavailable = errors.New("database is unavailable")
+
+// ConnectError is returned when a database can't be connected after all retries.
+// Err is the last driver error, or the error of the startup context wrapping it.
+type ConnectError struct {
+	Type     string
+	Attempts int
+	Err      error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("failed to connect %s database after %d attempts: %s", e.Type, e.Attempts, e.Err.Error())
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// exponential backoff of connection retries, defined in the database config:
+//
+//	retry: 5                # max retries, no retry by default
+//	retryinterval: 1s       # delay before the first retry, doubled after each failure
+//	retrymaxinterval: 30s   # max delay between retries
+//	retrytimeout: 2m        # max time of all attempts. Retries until it if retry is not defined.
+type backoff struct {
+	retries     int
+	interval    time.Duration
+	maxInterval time.Duration
+	timeout     time.Duration
+}
+
+func newBackoff(conf *config.Config) backoff {
+	b := backoff{interval: time.Second, maxInterval: 30 * time.Second}
+	if conf == nil {
+		return b
+	}
+
+	b.retries = conf.GetInt("retry", 0)
+	for key, value := range map[string]*time.Duration{
+		"retryinterval":    &b.interval,
+		"retrymaxinterval": &b.maxInterval,
+		"retrytimeout":     &b.timeout,
+	} {
+		if conf.GetString(key) == "" {
+			continue
+		}
+		d, err := time.ParseDuration(conf.GetString(key))
+		if err != nil {
+			logging.Warnw("invalid retry duration, using default", key, conf.GetString(key))
+			continue
+		}
+		*value = d
+	}
+	return b
+}
+
+// delay before the retry after attempts failures, with jitter so instances don't retry at the same time
+func (b backoff) delay(attempts int) time.Duration {
+	d := b.interval
+	for i := 1; i < attempts && d < b.maxInterval; i++ {
+		d *= 2
+	}
+	if d > b.maxInterval {
+		d = b.maxInterval
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// whether to retry after attempts failures in elapsed time, and the delay before it
+func (b backoff) next(attempts int, elapsed time.Duration) (time.Duration, bool) {
+	if b.retries > 0 && attempts > b.retries {
+		return 0, false
+	}
+	if b.retries <= 0 && b.timeout <= 0 {
+		return 0, false
+	}
+	delay := b.delay(attempts)
+	if b.timeout > 0 && elapsed+delay > b.timeout {
+		return 0, false
+	}
+	return delay, true
+}
+
+type noRetryKey struct{}
+
+// connect once only, e.g. reconnecting lazily in a request
+func withoutRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+// connect with fn, retrying with backoff of conf until ctx is done
+func connectDatabase(ctx context.Context, dbType string, conf *config.Config, fn func() (*gorm.DB, error)) (*gorm.DB, error) {
+	b := newBackoff(conf)
+	if noRetry, _ := ctx.Value(noRetryKey{}).(bool); noRetry {
+		b = backoff{}
+	}
+
+	start := time.Now()
+	for attempts := 1; ; attempts++ {
+		db, err := fn()
+		if err == nil {
+			if err = db.Use(tracingPlugin{}); err != nil {
+				logging.Errorf("failed to enable tracing for db: %s", err.Error())
+			}
+			return db, nil
+		}
+
+		delay, ok := b.next(attempts, time.Since(start))
+		if !ok {
+			return nil, &ConnectError{Type: dbType, Attempts: attempts, Err: err}
+		}
+		logging.Warnw("failed to connect db, retrying", "type", dbType, "attempt", attempts, "delay", delay.String(), "error", err.Error())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &ConnectError{Type: dbType, Attempts: attempts, Err: fmt.Errorf("%w, last error: %s", ctx.Err(), err.Error())}
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/skema-dev/skema-go/health"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectRetry(t *testing.T) {
+	_, err := data.NewSqliteDatabase(config.NewConfigWithString(`
+filepath: missing/retry.db
+retry: 2
+retryinterval: 10ms
+`))
+	connectErr := &data.ConnectError{}
+	assert.True(t, errors.As(err, &connectErr))
+	assert.Equal(t, "sqlite", connectErr.Type)
+	assert.Equal(t, 3, connectErr.Attempts)
+	assert.NotNil(t, errors.Unwrap(err))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = data.NewSqliteDatabaseContext(ctx, config.NewConfigWithString(`
+filepath: missing/retry.db
+retry: 100
+retryinterval: 1s
+`))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestDegradedStart(t *testing.T) {
+	defer os.RemoveAll("degraded")
+	defer health.Unregister("database:degraded1")
+
+	manager := data.NewDataManager().WithDegradedStart().WithConfig(config.NewConfigWithString(`
+database:
+    degraded1:
+        type: sqlite
+        filepath: degraded/test.db
+        automigrate: true
+        retryinterval: 10ms
+`), "database")
+
+	assert.Nil(t, manager.GetDB("degraded1"))
+	assert.True(t, errors.Is(health.CheckAll(context.Background())["database:degraded1"], data.ErrDatabaseUnavailable))
+
+	// the database becomes available, and is connected by the next call after the retry interval
+	assert.Nil(t, os.Mkdir("degraded", 0755))
+	time.Sleep(50 * time.Millisecond)
+
+	dao := manager.GetDaoForDb("degraded1", &PlainModel{})
+	assert.NotNil(t, dao)
+	assert.Nil(t, dao.Create(&PlainModel{UUID: "user1"}))
+	assert.Nil(t, health.CheckAll(context.Background())["database:degraded1"])
+}
+
+func TestDegradedSetup(t *testing.T) {
+	defer os.RemoveAll("degraded_setup.db")
+	defer health.Unregister("database:degraded2")
+
+	dir := t.TempDir()
+	migration := filepath.Join(dir, "0001_create_users.up.sql")
+	os.WriteFile(migration, []byte("CREATE TABLE;"), 0644)
+	manager := data.NewDataManager().WithDegradedStart().WithConfig(config.NewConfigWithString(`
+database:
+    degraded2:
+        type: sqlite
+        filepath: degraded_setup.db
+        retryinterval: 10ms
+        migrations:
+            dir: `+dir+`
+            run: true
+`), "database")
+
+	// connected, but kept pending since migrations failed
+	assert.Nil(t, manager.GetDB("degraded2"))
+	assert.True(t, errors.Is(health.CheckAll(context.Background())["database:degraded2"], data.ErrDatabaseUnavailable))
+
+	os.WriteFile(migration, []byte("CREATE TABLE degraded_users (id integer primary key);"), 0644)
+	time.Sleep(50 * time.Millisecond)
+
+	db := manager.GetDB("degraded2")
+	assert.NotNil(t, db)
+	assert.True(t, db.Migrator().HasTable("degraded_users"))
+}
@@ -0,0 +1,330 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/skema-dev/skema-go/logging"
+)
+
+const (
+	defaultFacetSize    = 10
+	defaultHighlightPre = "<em>"
+	defaultHighlightEnd = "</em>"
+)
+
+// SearchRequest of full text search in elastic, e.g.
+//
+//	request := data.SearchRequest{
+//		Text:      "red shoes",
+//		Fields:    []string{"Title^3", "Description"},
+//		Fuzziness: "AUTO",
+//		Filter:    data.Eq("Status", "online"),
+//		Highlight: []string{"Title"},
+//		Facets:    []data.Facet{data.TermsFacet("Brand", 20), data.DateHistogramFacet("CreatedAt", "month")},
+//		Size:      20,
+//	}
+type SearchRequest struct {
+	// full text to search, all records are matched if empty
+	Text string
+	// fields or columns to search the text in, with optional boosts like "Title^3". All fields by default
+	Fields []string
+	// fuzziness of terms for typos, e.g. AUTO, 1 or 2. No fuzzy matching if empty
+	Fuzziness string
+	// match all terms of the text instead of any
+	MatchAll bool
+	// Filter, QueryParams or *QueryParams narrowing the results without affecting scores
+	Filter interface{}
+	// fields or columns to highlight
+	Highlight []string
+	// tags around highlighted terms, <em> and </em> by default
+	PreTag  string
+	PostTag string
+	// aggregations of all matched records
+	Facets []Facet
+	// sql order like "age desc, name", by relevance if empty
+	Order string
+	From  int
+	// number of records returned, 10 by default
+	Size int
+}
+
+// Facet is an aggregation of the matched records into buckets, created by TermsFacet or DateHistogramFacet
+type Facet struct {
+	field    string
+	size     int
+	interval string
+}
+
+// TermsFacet counts the matched records by distinct values of the field, returning the top size buckets.
+// size is 10 if it's not positive.
+func TermsFacet(field string, size int) Facet {
+	if size <= 0 {
+		size = defaultFacetSize
+	}
+	return Facet{field: field, size: size}
+}
+
+// DateHistogramFacet counts the matched records by the calendar interval of the date field,
+// e.g. minute, hour, day, week, month, quarter or year
+func DateHistogramFacet(field string, interval string) Facet {
+	return Facet{field: field, interval: interval}
+}
+
+// SearchResult of Search besides the records
+type SearchResult struct {
+	// number of all matched records
+	Total int64
+	// scores and highlights in the same order as the records
+	Hits []SearchHit
+	// buckets by the fields of facets
+	Facets map[string][]Bucket
+}
+
+// SearchHit is the relevance of a returned record
+type SearchHit struct {
+	ID    string
+	Score float64
+	// highlighted fragments by fields
+	Highlights map[string][]string
+}
+
+// Bucket of a facet. Keys of date histograms are formatted as RFC3339.
+type Bucket struct {
+	Key   string
+	Count int64
+}
+
+// Search records in elastic by full text, with highlights, facets and relevance scores.
+// result should be a pointer to a slice of the model, the same as Query.
+func (d *DAO) Search(request SearchRequest, result interface{}) (*SearchResult, error) {
+	return d.SearchContext(context.Background(), request, result)
+}
+
+// SearchContext is the same as Search, searching with ctx. Searches are always served by elastic,
+// regardless of the read preference.
+func (d *DAO) SearchContext(ctx context.Context, request SearchRequest, result interface{}) (searchResult *SearchResult, err error) {
+	ctx, span := d.startSpan(ctx, "search")
+	defer d.observe("search", time.Now(), span, &err)
+
+	if err = d.requireElastic(); err != nil {
+		return nil, err
+	}
+	searcher, ok := d.es.(elastic.BodySearcher)
+	if !ok {
+		return nil, fmt.Errorf("full text search for [%s]: %w", d.model.TableName(), elastic.ErrNotSupported)
+	}
+	if err = d.ensureIndex(ctx); err != nil {
+		return nil, logging.Errorf("failed to create elastic index %s: %s", d.esIndexName(), err.Error())
+	}
+
+	body, err := d.searchBody(request)
+	if err != nil {
+		return nil, logging.Errorf("invalid search for %s: %s", d.esIndexName(), err.Error())
+	}
+	response, err := searcher.SearchBody(ctx, d.esIndexName(), body)
+	if err != nil {
+		return nil, err
+	}
+	d.report(ctx, span, BackendSearch, nil)
+
+	items := reflect.MakeSlice(reflect.SliceOf(d.modelType()), 0, len(response.Hits))
+	searchResult = &SearchResult{Total: response.Total, Hits: make([]SearchHit, 0, len(response.Hits))}
+	for _, hit := range response.Hits {
+		value := reflect.New(d.modelType())
+		elastic.ConvertMapToStruct(hit.Source, value.Interface())
+		items = reflect.Append(items, value.Elem())
+		searchResult.Hits = append(searchResult.Hits, SearchHit{ID: hit.ID, Score: hit.Score, Highlights: hit.Highlight})
+	}
+	reflectedResult := reflect.ValueOf(result).Elem()
+	reflectedResult.Set(items.Convert(reflectedResult.Type()))
+
+	if searchResult.Facets, err = parseFacets(request.Facets, response.Aggregations); err != nil {
+		return nil, logging.Errorf("invalid aggregations of %s: %s", d.esIndexName(), err.Error())
+	}
+	return searchResult, nil
+}
+
+// body of _search api for the request
+func (d *DAO) searchBody(request SearchRequest) (map[string]interface{}, error) {
+	filter, err := toFilter(request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	filterQuery, err := d.scopeFilter(filter, scopeActive).elasticQuery(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var textQuery map[string]interface{}
+	if request.Text == "" {
+		textQuery = map[string]interface{}{"match_all": map[string]interface{}{}}
+	} else {
+		match := map[string]interface{}{"query": request.Text}
+		if len(request.Fields) > 0 {
+			fields := make([]string, 0, len(request.Fields))
+			for _, name := range request.Fields {
+				name, boost, _ := strings.Cut(name, "^")
+				property, err := d.documentProperty(name)
+				if err != nil {
+					return nil, err
+				}
+				if boost != "" {
+					property += "^" + boost
+				}
+				fields = append(fields, property)
+			}
+			match["fields"] = fields
+		}
+		if request.Fuzziness != "" {
+			match["fuzziness"] = request.Fuzziness
+		}
+		if request.MatchAll {
+			match["operator"] = "and"
+		}
+		textQuery = map[string]interface{}{"multi_match": match}
+	}
+
+	body := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{"must": textQuery, "filter": filterQuery},
+		},
+		"track_total_hits": true,
+	}
+	if request.From > 0 {
+		body["from"] = request.From
+	}
+	if request.Size > 0 {
+		body["size"] = request.Size
+	}
+
+	if request.Order != "" {
+		sorts := []interface{}{}
+		for _, item := range strings.Split(request.Order, ",") {
+			name, direction, _ := strings.Cut(strings.TrimSpace(item), " ")
+			if name == "" {
+				continue
+			}
+			direction = strings.ToLower(strings.TrimSpace(direction))
+			if direction == "" {
+				direction = "asc"
+			} else if direction != "asc" && direction != "desc" {
+				return nil, fmt.Errorf("invalid order direction %s", direction)
+			}
+			field, err := d.exactField(name)
+			if err != nil {
+				return nil, err
+			}
+			sorts = append(sorts, map[string]interface{}{field: direction})
+		}
+		body["sort"] = sorts
+	}
+
+	if len(request.Highlight) > 0 {
+		fields := map[string]interface{}{}
+		for _, name := range request.Highlight {
+			property, err := d.documentProperty(name)
+			if err != nil {
+				return nil, err
+			}
+			fields[property] = map[string]interface{}{}
+		}
+		pre, post := request.PreTag, request.PostTag
+		if pre == "" && post == "" {
+			pre, post = defaultHighlightPre, defaultHighlightEnd
+		}
+		body["highlight"] = map[string]interface{}{
+			"pre_tags":  []string{pre},
+			"post_tags": []string{post},
+			"fields":    fields,
+		}
+	}
+
+	if len(request.Facets) > 0 {
+		aggs := map[string]interface{}{}
+		for _, facet := range request.Facets {
+			if facet.interval != "" {
+				property, err := d.documentProperty(facet.field)
+				if err != nil {
+					return nil, err
+				}
+				aggs[facet.field] = map[string]interface{}{
+					"date_histogram": map[string]interface{}{"field": property, "calendar_interval": facet.interval},
+				}
+				continue
+			}
+			field, err := d.exactField(facet.field)
+			if err != nil {
+				return nil, err
+			}
+			aggs[facet.field] = map[string]interface{}{
+				"terms": map[string]interface{}{"field": field, "size": facet.size},
+			}
+		}
+		body["aggs"] = aggs
+	}
+	return body, nil
+}
+
+// property of the field or column in the document
+func (d *DAO) documentProperty(name string) (string, error) {
+	field, err := d.fieldName(name)
+	if err != nil {
+		return "", err
+	}
+	return d.property(field), nil
+}
+
+// property of the field or column for sort and terms aggregation, the keyword sub field for texts
+func (d *DAO) exactField(name string) (string, error) {
+	field, err := d.fieldName(name)
+	if err != nil {
+		return "", err
+	}
+	return d.sortField(field), nil
+}
+
+// buckets of the facets from the raw aggregations
+func parseFacets(facets []Facet, aggregations map[string]interface{}) (map[string][]Bucket, error) {
+	if len(facets) == 0 {
+		return nil, nil
+	}
+	result := map[string][]Bucket{}
+	for _, facet := range facets {
+		aggregation, _ := aggregations[facet.field].(map[string]interface{})
+		items, _ := aggregation["buckets"].([]interface{})
+		buckets := make([]Bucket, 0, len(items))
+		for _, item := range items {
+			bucket, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid bucket of %s", facet.field)
+			}
+			count, _ := bucket["doc_count"].(float64)
+			buckets = append(buckets, Bucket{Key: bucketKey(facet, bucket), Count: int64(count)})
+		}
+		result[facet.field] = buckets
+	}
+	return result, nil
+}
+
+func bucketKey(facet Facet, bucket map[string]interface{}) string {
+	switch key := bucket["key"].(type) {
+	case string:
+		return key
+	case float64:
+		if facet.interval != "" {
+			return time.UnixMilli(int64(key)).UTC().Format(time.RFC3339)
+		}
+		if s, ok := bucket["key_as_string"].(string); ok {
+			// booleans
+			return s
+		}
+		return strconv.FormatFloat(key, 'f', -1, 64)
+	}
+	return fmt.Sprint(bucket["key"])
+}
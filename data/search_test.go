@@ -0,0 +1,113 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/stretchr/testify/assert"
+)
+
+// scoringElastic records the search bodies and returns the response for all searches
+type scoringElastic struct {
+	recordingElastic
+	bodies   []map[string]interface{}
+	response *elastic.SearchResponse
+}
+
+func (e *scoringElastic) SearchBody(ctx context.Context, index string, body map[string]interface{}) (*elastic.SearchResponse, error) {
+	e.bodies = append(e.bodies, body)
+	return e.response, nil
+}
+
+func TestSearch(t *testing.T) {
+	defer os.RemoveAll("search.db")
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: search.db
+        automigrate: true
+`), "database")
+
+	plain := data.GetTypedDAO[TestModel1](manager, "db1")
+	_, _, err := plain.Search(data.SearchRequest{Text: "user"})
+	assert.NotNil(t, err)
+
+	es := &scoringElastic{
+		recordingElastic: recordingElastic{indexed: map[string]bool{}},
+		response: &elastic.SearchResponse{
+			Total: 12,
+			Hits: []elastic.Hit{
+				{ID: "1", Score: 2.5, Source: map[string]interface{}{"Name": "user1"}, Highlight: map[string][]string{"Name": {"<b>user1</b>"}}},
+				{ID: "2", Score: 1.2, Source: map[string]interface{}{"Name": "user2"}},
+			},
+			Aggregations: map[string]interface{}{
+				"Name": map[string]interface{}{"buckets": []interface{}{
+					map[string]interface{}{"key": "user1", "doc_count": float64(10)},
+				}},
+				"CreatedAt": map[string]interface{}{"buckets": []interface{}{
+					map[string]interface{}{"key": float64(1672531200000), "key_as_string": "2023-01-01T00:00:00.000Z", "doc_count": float64(12)},
+				}},
+			},
+		},
+	}
+	manager = data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: search.db
+`), "database")
+	manager.GetDB("db1").SetElastic(es)
+	users := data.GetTypedDAO[TestModel1](manager, "db1")
+
+	report := &data.QueryReport{}
+	ctx := data.WithQueryReport(data.WithReadPreference(context.Background(), data.ReadPrimary), report)
+	list, result, err := users.SearchContext(ctx, data.SearchRequest{
+		Text:      "usr",
+		Fields:    []string{"name^2"},
+		Fuzziness: "AUTO",
+		Filter:    data.Eq("Name", "user1"),
+		Highlight: []string{"Name"},
+		PreTag:    "<b>",
+		PostTag:   "</b>",
+		Facets:    []data.Facet{data.TermsFacet("Name", 0), data.DateHistogramFacet("CreatedAt", "month")},
+		Order:     "CreatedAt desc",
+		Size:      2,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, data.BackendSearch, report.Backend)
+	assert.Equal(t, []string{"user1", "user2"}, []string{list[0].Name, list[1].Name})
+	assert.Equal(t, int64(12), result.Total)
+	assert.Equal(t, 2.5, result.Hits[0].Score)
+	assert.Equal(t, []string{"<b>user1</b>"}, result.Hits[0].Highlights["Name"])
+	assert.Equal(t, []data.Bucket{{Key: "user1", Count: 10}}, result.Facets["Name"])
+	assert.Equal(t, []data.Bucket{{Key: "2023-01-01T00:00:00Z", Count: 12}}, result.Facets["CreatedAt"])
+
+	body := es.bodies[0]
+	query := body["query"].(map[string]interface{})["bool"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"multi_match": map[string]interface{}{
+		"query": "usr", "fields": []string{"Name^2"}, "fuzziness": "AUTO",
+	}}, query["must"])
+	assert.NotNil(t, query["filter"])
+	assert.Equal(t, 2, body["size"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"CreatedAt": "desc"}}, body["sort"])
+	aggs := body["aggs"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"terms": map[string]interface{}{"field": "Name.keyword", "size": 10}}, aggs["Name"])
+	assert.Equal(t, map[string]interface{}{
+		"date_histogram": map[string]interface{}{"field": "CreatedAt", "calendar_interval": "month"},
+	}, aggs["CreatedAt"])
+
+	_, _, err = users.Search(data.SearchRequest{Text: "user", Fields: []string{"unknown"}})
+	assert.NotNil(t, err)
+
+	// clients without SearchBody can't search by full text
+	dao := data.NewDAO(manager.GetDB("db1"), &TestModel1{})
+	dao.SetElasticClient(struct{ elastic.Elastic }{es})
+	_, err = dao.Search(data.SearchRequest{Text: "user"}, &[]TestModel1{})
+	assert.True(t, errors.Is(err, elastic.ErrNotSupported))
+}
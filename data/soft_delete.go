@@ -0,0 +1,169 @@
+package data
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// which records are visible to a query, for models with gorm.DeletedAt
+type deleteScope int
+
+const (
+	scopeActive deleteScope = iota
+	scopeWithDeleted
+	scopeDeletedOnly
+)
+
+// QueryWithDeleted is the same as Query, including soft deleted records
+func (d *DAO) QueryWithDeleted(query interface{}, result interface{}, options ...QueryOption) error {
+	return d.QueryWithDeletedContext(context.Background(), query, result, options...)
+}
+
+func (d *DAO) QueryWithDeletedContext(ctx context.Context, query interface{}, result interface{}, options ...QueryOption) error {
+	return d.query(ctx, scopeWithDeleted, query, result, options...)
+}
+
+// QueryDeletedOnly is the same as Query, returning soft deleted records only
+func (d *DAO) QueryDeletedOnly(query interface{}, result interface{}, options ...QueryOption) error {
+	return d.QueryDeletedOnlyContext(context.Background(), query, result, options...)
+}
+
+func (d *DAO) QueryDeletedOnlyContext(ctx context.Context, query interface{}, result interface{}, options ...QueryOption) error {
+	return d.query(ctx, scopeDeletedOnly, query, result, options...)
+}
+
+// Restore soft deleted records matching query
+func (d *DAO) Restore(query interface{}, args ...interface{}) error {
+	return d.RestoreContext(context.Background(), query, args...)
+}
+
+// RestoreContext is the same as Restore, running sql and elastic requests with ctx
+func (d *DAO) RestoreContext(ctx context.Context, query interface{}, args ...interface{}) (err error) {
+	ctx, span := d.startSpan(ctx, "restore")
+	defer d.observe("restore", time.Now(), span, &err)
+
+	db, ids, err := d.findDeleted(ctx, query, args...)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	err = d.atomically(db, func(db *gorm.DB) error {
+		// skip hooks, which would change the model shared by the dao
+		if err := db.UpdateColumn(d.deletedAt, nil).Error; err != nil {
+			return err
+		}
+		return d.enqueue(db, ids...)
+	})
+	if err != nil {
+		return logging.Errorf("restore failed for [%s]: %s", d.model.TableName(), err.Error())
+	}
+	d.reindex(ctx, ids)
+	return nil
+}
+
+// Purge permanently deletes soft deleted records matching query, from both database and elastic
+func (d *DAO) Purge(query interface{}, args ...interface{}) error {
+	return d.PurgeContext(context.Background(), query, args...)
+}
+
+// PurgeContext is the same as Purge, running sql and elastic requests with ctx
+func (d *DAO) PurgeContext(ctx context.Context, query interface{}, args ...interface{}) (err error) {
+	ctx, span := d.startSpan(ctx, "purge")
+	defer d.observe("purge", time.Now(), span, &err)
+
+	db, ids, err := d.findDeleted(ctx, query, args...)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	err = d.atomically(db, func(db *gorm.DB) error {
+		if err := db.Delete(&d.model).Error; err != nil {
+			return err
+		}
+		return d.enqueue(db, ids...)
+	})
+	if err != nil {
+		return logging.Errorf("purge failed for [%s]: %s", d.model.TableName(), err.Error())
+	}
+	if d.db.outbox == nil {
+		d.afterWrite(ctx, func(ctx context.Context) { d.deleteFromElastic(ctx, ids) })
+	}
+	return nil
+}
+
+// soft deleted records matching query, and their uuids
+func (d *DAO) findDeleted(ctx context.Context, query interface{}, args ...interface{}) (*gorm.DB, []string, error) {
+	if d.deletedAt == "" {
+		return nil, nil, logging.Errorf("%s is not soft deleted", d.Name())
+	}
+
+	db, err := d.where(d.scoped(d.db.WithContext(ctx).Model(&d.model), scopeDeletedOnly), query, args...)
+	if err != nil {
+		return nil, nil, logging.Errorf("invalid query for [%s]: %s", d.model.TableName(), err.Error())
+	}
+	ids, err := d.matchingIDs(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, ids, nil
+}
+
+// replace gorm's default scope by the delete scope
+func (d *DAO) scoped(db *gorm.DB, scope deleteScope) *gorm.DB {
+	if d.deletedAt == "" || scope == scopeActive {
+		return db
+	}
+
+	db = db.Unscoped()
+	if scope == scopeDeletedOnly {
+		db = db.Where(clause.Neq{Column: clause.Column{Name: d.deletedAt}, Value: nil})
+	}
+	return db
+}
+
+// elasticsearch keeps soft deleted documents, so the delete scope is applied as a filter on DeletedAt
+func (d *DAO) scopeFilter(filter Filter, scope deleteScope) Filter {
+	if d.deletedAt == "" {
+		return filter
+	}
+
+	switch scope {
+	case scopeActive:
+		return And(filter, IsNull(d.deletedAt))
+	case scopeDeletedOnly:
+		return And(filter, NotNull(d.deletedAt))
+	}
+	return filter
+}
+
+// index the records by uuids again, e.g. after soft delete or restore.
+// Records are loaded now so they are read in the transaction, and indexed after commit.
+func (d *DAO) reindex(ctx context.Context, ids []string) {
+	if d.es == nil || d.db.outbox != nil || len(ids) == 0 {
+		return
+	}
+
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	items := reflect.New(reflect.SliceOf(d.modelType()))
+	tx := d.db.primary(d.db.WithContext(ctx).Unscoped()).
+		Where(clause.IN{Column: clause.Column{Name: d.idColumn()}, Values: values}).
+		Find(items.Interface())
+	if tx.Error != nil {
+		logging.Errorf("failed to load records to reindex for [%s]: %s", d.model.TableName(), tx.Error.Error())
+		return
+	}
+
+	d.afterWrite(ctx, func(ctx context.Context) {
+		for i := 0; i < items.Elem().Len(); i++ {
+			d.updateElasticIndex(ctx, items.Elem().Index(i).Addr().Interface().(DaoModel))
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package data_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+)
+
+type SoftDeleteModel struct {
+	data.Model
+	Name string
+}
+
+func (SoftDeleteModel) TableName() string {
+	return "soft_delete"
+}
+
+func TestSoftDelete(t *testing.T) {
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: softdelete.db
+        automigrate: true
+`), "database")
+	defer os.RemoveAll("softdelete.db")
+
+	es := &recordingElastic{indexed: map[string]bool{}}
+	manager.GetDB("db1").SetElastic(es)
+	dao := manager.GetDaoForDb("db1", &SoftDeleteModel{})
+	user2 := &SoftDeleteModel{Name: "user2"}
+	assert.Nil(t, dao.Create(&SoftDeleteModel{Name: "user1"}))
+	assert.Nil(t, dao.Create(user2))
+	assert.Nil(t, dao.Create(&SoftDeleteModel{Name: "user3"}))
+
+	assert.Nil(t, dao.Delete(data.In("name", "user1", "user2")))
+	// soft deleted documents are indexed again instead of removed
+	assert.Equal(t, 0, len(es.deleted))
+
+	rs := []SoftDeleteModel{}
+	assert.Nil(t, dao.Query(nil, &rs))
+	assert.Equal(t, 1, len(rs))
+
+	rs = []SoftDeleteModel{}
+	assert.Nil(t, dao.QueryWithDeleted(nil, &rs))
+	assert.Equal(t, 3, len(rs))
+
+	rs = []SoftDeleteModel{}
+	assert.Nil(t, dao.QueryDeletedOnly(nil, &rs, data.QueryOption{Order: "name"}))
+	assert.Equal(t, 2, len(rs))
+	assert.Equal(t, "user1", rs[0].Name)
+	search, _ := json.Marshal(es.searches[len(es.searches)-1])
+	assert.Contains(t, string(search), `{"exists":{"field":"DeletedAt"}}`)
+	assert.NotContains(t, string(search), `must_not`)
+
+	assert.Nil(t, dao.Restore(&data.QueryParams{"name": "user1"}))
+	rs = []SoftDeleteModel{}
+	dao.Query(nil, &rs)
+	assert.Equal(t, 2, len(rs))
+
+	// only soft deleted records are purged
+	assert.Nil(t, dao.Purge(data.Like("name", "user%")))
+	assert.Equal(t, []string{user2.UUID}, es.deleted)
+	rs = []SoftDeleteModel{}
+	dao.QueryWithDeleted(nil, &rs)
+	assert.Equal(t, 2, len(rs))
+
+	// purge in a transaction removes documents after commit
+	assert.Nil(t, dao.Delete("name = ?", "user3"))
+	err := manager.Transaction("db1", func(tx *data.TxScope) error {
+		dao, err := tx.DAO(&SoftDeleteModel{})
+		if err != nil {
+			return err
+		}
+		if err := dao.Purge("name = ?", "user3"); err != nil {
+			return err
+		}
+		assert.Equal(t, 1, len(es.deleted))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return len(es.deleted) == 2 }, time.Second, 10*time.Millisecond)
+
+	plain := data.NewDAO(manager.GetDB("db1"), &PlainModel{})
+	plain.Automigrate()
+	assert.NotNil(t, plain.Restore(nil))
+	assert.NotNil(t, plain.QueryDeletedOnly(nil, &[]PlainModel{}))
+}
+
+type PlainModel struct {
+	ID   uint   `gorm:"primarykey"`
+	UUID string `gorm:"column:uuid"`
+}
+
+func (PlainModel) TableName() string {
+	return "plain"
+}
+
+func (m PlainModel) PrimaryID() string {
+	return m.UUID
+}
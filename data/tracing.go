@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+
+	"github.com/skema-dev/skema-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const tracingSpanKey = "skema:tracing_span"
+
+type gormSpan struct {
+	parent context.Context
+	span   trace.Span
+}
+
+// tracingPlugin creates a span for every sql statement, as a child of the span in the statement context.
+// Use db.WithContext(ctx) to link the statement to the current request.
+type tracingPlugin struct{}
+
+func (tracingPlugin) Name() string {
+	return "skema:tracing"
+}
+
+func (p tracingPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+
+	for _, h := range hooks {
+		if err := h.before("skema:tracing_before_"+h.operation, p.before(h.operation)); err != nil {
+			return err
+		}
+		if err := h.after("skema:tracing_after_"+h.operation, p.after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tracingPlugin) before(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx, span := tracing.Start(tx.Statement.Context, "gorm."+operation,
+			semconv.DBSystemKey.String(tx.Dialector.Name()),
+			semconv.DBOperationKey.String(operation),
+		)
+		tx.InstanceSet(tracingSpanKey, &gormSpan{parent: tx.Statement.Context, span: span})
+		tx.Statement.Context = ctx
+	}
+}
+
+func (tracingPlugin) after(tx *gorm.DB) {
+	v, ok := tx.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	s := v.(*gormSpan)
+	tx.Statement.Context = s.parent
+
+	s.span.SetAttributes(
+		semconv.DBSQLTableKey.String(tx.Statement.Table),
+		semconv.DBStatementKey.String(tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.RowsAffected),
+	)
+	err := tx.Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	tracing.End(s.span, err)
+}
@@ -0,0 +1,49 @@
+package data_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/skema-dev/skema-go/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type TracedModel struct {
+	data.Model
+	Name string
+}
+
+func (TracedModel) TableName() string {
+	return "traced"
+}
+
+func TestDAOTracing(t *testing.T) {
+	assert.Nil(t, tracing.Init(config.NewConfigWithString(`exporter: memory`)))
+	defer tracing.Shutdown(context.Background())
+
+	dbInstance, _ := data.NewMemoryDatabase(nil)
+	dao := data.NewDAO(dbInstance, &TracedModel{})
+	dao.Automigrate()
+	tracing.MemoryExporter().Reset()
+
+	assert.Nil(t, dao.Create(&TracedModel{Name: "user1"}))
+	var results []TracedModel
+	assert.Nil(t, dao.Query(&data.QueryParams{"name": "user1"}, &results))
+	assert.Equal(t, 1, len(results))
+
+	spans := tracing.MemoryExporter().GetSpans()
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	assert.Equal(t, []string{"gorm.create", "dao.create", "gorm.query", "dao.query"}, names)
+
+	// sql spans are nested in the dao span
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, spans[3].SpanContext.SpanID(), spans[2].Parent.SpanID())
+	assert.Equal(t, trace.SpanKindInternal, spans[1].SpanKind)
+	assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind)
+}
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm"
+)
+
+// TxScope hands out DAOs bound to the same database transaction.
+// Elastic index updates and deletes of these DAOs are held until the transaction is committed,
+// and discarded if it's rolled back.
+type TxScope struct {
+	ctx     context.Context
+	dbKey   string
+	db      *Database
+	manager *DataManager
+
+	daos    map[string]*DAO
+	pending []func()
+}
+
+// DAO bound to the transaction for the model. The model must be registered for the database already,
+// either in config or by DataManager.GetDaoForDb(), since tables can't be migrated in the transaction.
+// An error is returned if it's not registered, e.g.
+//
+//	users, err := tx.DAO(&model.User{})
+//	if err != nil {
+//		return err
+//	}
+func (t *TxScope) DAO(model DaoModel) (*DAO, error) {
+	if dao, ok := t.daos[model.TableName()]; ok {
+		return dao, nil
+	}
+
+	base := t.manager.findDao(t.dbKey, model.TableName())
+	if base == nil {
+		return nil, logging.Errorf("dao is not registered for %s:%s", t.dbKey, model.TableName())
+	}
+
+	// share the event subscriptions of the registered dao, only replace the db handle
+	dao := *base
+	dao.db = t.db
+	dao.tx = t
+	t.daos[model.TableName()] = &dao
+	return &dao, nil
+}
+
+// DB returns the transaction, for operations not covered by DAO
+func (t *TxScope) DB() *Database {
+	return t.db
+}
+
+// Context of the transaction
+func (t *TxScope) Context() context.Context {
+	return t.ctx
+}
+
+func (t *TxScope) afterCommit(fn func()) {
+	t.pending = append(t.pending, fn)
+}
+
+// Transaction runs fn in a database transaction. It's committed if fn returns nil, otherwise rolled back.
+func (d *DataManager) Transaction(dbKey string, fn func(tx *TxScope) error) error {
+	return d.TransactionContext(context.Background(), dbKey, fn)
+}
+
+// TransactionContext is the same as Transaction, running the transaction with ctx
+func (d *DataManager) TransactionContext(ctx context.Context, dbKey string, fn func(tx *TxScope) error) error {
+	db := d.GetDB(dbKey)
+	if db == nil {
+		return errors.New("cannot find database with key " + dbKey)
+	}
+
+	var scope *TxScope
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		scope = &TxScope{
+			ctx:     ctx,
+			dbKey:   dbKey,
+			db:      db.withDB(tx),
+			manager: d,
+			daos:    map[string]*DAO{},
+		}
+		return fn(scope)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range scope.pending {
+		f()
+	}
+	return nil
+}
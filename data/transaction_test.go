@@ -0,0 +1,184 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/skema-dev/skema-go/elastic"
+	"github.com/stretchr/testify/assert"
+)
+
+// elastic client recording distinct indexed ids, searching always fails so queries fallback to db
+type recordingElastic struct {
+	mux      sync.Mutex
+	indexed  map[string]bool
+	deleted  []string
+	searches []map[string]interface{}
+	options  []*elastic.SearchOption
+	bulks    int
+	// body of created indexes
+	created map[string]map[string]interface{}
+}
+
+func (e *recordingElastic) Index(index string, id string, value interface{}) error {
+	return e.IndexContext(context.Background(), index, id, value)
+}
+
+func (e *recordingElastic) IndexContext(ctx context.Context, index string, id string, value interface{}) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.indexed[id] = true
+	return nil
+}
+
+func (e *recordingElastic) BulkIndex(index string, docs []elastic.Document) error {
+	return e.BulkIndexContext(context.Background(), index, docs)
+}
+
+func (e *recordingElastic) BulkIndexContext(ctx context.Context, index string, docs []elastic.Document) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.bulks++
+	for _, doc := range docs {
+		e.indexed[doc.ID] = true
+	}
+	return nil
+}
+
+func (e *recordingElastic) Search(index string, termQueryType string, query map[string]interface{}, option *elastic.SearchOption) ([]map[string]interface{}, error) {
+	return nil, errors.New("not supported")
+}
+
+func (e *recordingElastic) SearchContext(ctx context.Context, index string, termQueryType string, query map[string]interface{}, option *elastic.SearchOption) ([]map[string]interface{}, error) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.searches = append(e.searches, map[string]interface{}{termQueryType: query})
+	e.options = append(e.options, option)
+	return nil, errors.New("not supported")
+}
+
+func (e *recordingElastic) Delete(index string, ids []string) {
+	e.DeleteContext(context.Background(), index, ids)
+}
+
+func (e *recordingElastic) DeleteContext(ctx context.Context, index string, ids []string) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.deleted = append(e.deleted, ids...)
+	return nil
+}
+
+func (e *recordingElastic) DeleteIndex(indexes []string) {}
+
+func (e *recordingElastic) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (e *recordingElastic) CreateIndex(ctx context.Context, index string, body map[string]interface{}) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.created == nil {
+		e.created = map[string]map[string]interface{}{}
+	}
+	e.created[index] = body
+	return nil
+}
+
+func (e *recordingElastic) Scan(ctx context.Context, index string, size int, fn func(docs []elastic.Document) error) error {
+	return errors.New("not supported")
+}
+
+func (e *recordingElastic) GetAlias(ctx context.Context, alias string) ([]string, error) {
+	return nil, nil
+}
+
+func (e *recordingElastic) SwapAlias(ctx context.Context, alias string, index string) error {
+	return errors.New("not supported")
+}
+
+func (e *recordingElastic) SearchBody(ctx context.Context, index string, body map[string]interface{}) (*elastic.SearchResponse, error) {
+	return nil, errors.New("search is down")
+}
+
+func (e *recordingElastic) indexedCount() int {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return len(e.indexed)
+}
+
+func TestTransaction(t *testing.T) {
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: tx.db
+        automigrate: true
+`), "database")
+	defer os.RemoveAll("tx.db")
+
+	es := &recordingElastic{indexed: map[string]bool{}}
+	manager.GetDB("db1").SetElastic(es)
+	manager.GetDaoForDb("db1", &TestModel1{})
+	manager.GetDaoForDb("db1", &TestModel2{})
+
+	err := manager.Transaction("db1", func(tx *data.TxScope) error {
+		users, err := tx.DAO(&TestModel1{})
+		if err != nil {
+			return err
+		}
+		if err := users.Create(&TestModel1{Name: "user1"}); err != nil {
+			return err
+		}
+		addresses, err := data.Typed[TestModel2](tx)
+		if err != nil {
+			return err
+		}
+		if err := addresses.Create(&TestModel2{Name: "address1"}); err != nil {
+			return err
+		}
+		// not indexed before commit
+		assert.Equal(t, 0, es.indexedCount())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return es.indexedCount() == 2 }, time.Second, 10*time.Millisecond)
+
+	err = manager.Transaction("db1", func(tx *data.TxScope) error {
+		users, _ := tx.DAO(&TestModel1{})
+		addresses, _ := tx.DAO(&TestModel2{})
+		users.Create(&TestModel1{Name: "user2"})
+		addresses.Create(&TestModel2{Name: "address2"})
+		users.Delete("name = ?", "user1")
+		return errors.New("rollback")
+	})
+	assert.NotNil(t, err)
+
+	users := []TestModel1{}
+	manager.GetDAO(&TestModel1{}).Query(&data.QueryParams{}, &users)
+	assert.Equal(t, 1, len(users))
+	addresses := []TestModel2{}
+	manager.GetDAO(&TestModel2{}).Query(&data.QueryParams{}, &addresses)
+	assert.Equal(t, 1, len(addresses))
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, es.indexedCount())
+	assert.Equal(t, 0, len(es.deleted))
+
+	// not registered, returned to the caller instead of panicking in the transaction
+	err = manager.Transaction("db1", func(tx *data.TxScope) error {
+		if _, err := data.Typed[TestModel3](tx); err == nil {
+			return errors.New("registered")
+		}
+		_, err := tx.DAO(&TestModel3{})
+		return err
+	})
+	assert.NotNil(t, err)
+
+	assert.NotNil(t, manager.Transaction("unknown", func(tx *data.TxScope) error { return nil }))
+}
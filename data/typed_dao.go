@@ -0,0 +1,252 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/skema-dev/skema-go/logging"
+	"gorm.io/gorm"
+)
+
+// TypedDAO is a type safe wrapper of DAO. T is the model struct (not pointer), e.g.
+//
+//	users := data.GetTypedDAO[model.User](data.Manager(), "")
+//	user, err := users.Get(id)
+//	list, err := users.List(&data.QueryParams{"nation": "china"}, data.QueryOption{Limit: 10})
+type TypedDAO[T DaoModel] struct {
+	dao *DAO
+}
+
+// NewTypedDAO creates a TypedDAO for the database, same as NewDAO. It returns nil if T is a pointer type.
+func NewTypedDAO[T DaoModel](db *Database) *TypedDAO[T] {
+	model, err := typedModel[T]()
+	if err != nil {
+		return nil
+	}
+	return &TypedDAO[T]{dao: NewDAO(db, model)}
+}
+
+// GetTypedDAO returns the TypedDAO of T registered in the database, registering it if not yet.
+// dbKey could be empty if there is only one database. It returns nil if T is a pointer type.
+func GetTypedDAO[T DaoModel](manager *DataManager, dbKey string) *TypedDAO[T] {
+	model, err := typedModel[T]()
+	if err != nil {
+		return nil
+	}
+	dao := manager.GetDaoForDb(dbKey, model)
+	if dao == nil {
+		return nil
+	}
+	return &TypedDAO[T]{dao: dao}
+}
+
+// Typed returns the TypedDAO of T bound to the transaction, or an error if T is not registered, see TxScope.DAO
+func Typed[T DaoModel](tx *TxScope) (*TypedDAO[T], error) {
+	model, err := typedModel[T]()
+	if err != nil {
+		return nil, err
+	}
+	dao, err := tx.DAO(model)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedDAO[T]{dao: dao}, nil
+}
+
+// Untyped returns the underlying DAO
+func (t *TypedDAO[T]) Untyped() *DAO {
+	return t.dao
+}
+
+func (t *TypedDAO[T]) Get(id string) (*T, error) {
+	return t.GetContext(context.Background(), id)
+}
+
+// GetContext returns the record by PrimaryID(), or gorm.ErrRecordNotFound if not found
+func (t *TypedDAO[T]) GetContext(ctx context.Context, id string) (*T, error) {
+	return t.FirstContext(ctx, &QueryParams{t.dao.idColumn(): id})
+}
+
+func (t *TypedDAO[T]) List(query interface{}, options ...QueryOption) ([]T, error) {
+	return t.ListContext(context.Background(), query, options...)
+}
+
+func (t *TypedDAO[T]) ListContext(ctx context.Context, query interface{}, options ...QueryOption) ([]T, error) {
+	result := []T{}
+	if err := t.dao.QueryContext(ctx, query, &result, options...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListPage returns one page of records by cursor, see DAO.QueryPage
+func (t *TypedDAO[T]) ListPage(query interface{}, option PageOption) ([]T, *Page, error) {
+	return t.ListPageContext(context.Background(), query, option)
+}
+
+func (t *TypedDAO[T]) ListPageContext(ctx context.Context, query interface{}, option PageOption) ([]T, *Page, error) {
+	result := []T{}
+	page, err := t.dao.QueryPageContext(ctx, query, &result, option)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, page, nil
+}
+
+// Search records by full text in elastic, see DAO.Search. Hits of the result are in the same order as the records.
+func (t *TypedDAO[T]) Search(request SearchRequest) ([]T, *SearchResult, error) {
+	return t.SearchContext(context.Background(), request)
+}
+
+func (t *TypedDAO[T]) SearchContext(ctx context.Context, request SearchRequest) ([]T, *SearchResult, error) {
+	result := []T{}
+	searchResult, err := t.dao.SearchContext(ctx, request, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, searchResult, nil
+}
+
+// ListWithDeleted is the same as List, including soft deleted records
+func (t *TypedDAO[T]) ListWithDeleted(query interface{}, options ...QueryOption) ([]T, error) {
+	return t.ListWithDeletedContext(context.Background(), query, options...)
+}
+
+func (t *TypedDAO[T]) ListWithDeletedContext(ctx context.Context, query interface{}, options ...QueryOption) ([]T, error) {
+	result := []T{}
+	if err := t.dao.QueryWithDeletedContext(ctx, query, &result, options...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListDeletedOnly is the same as List, returning soft deleted records only
+func (t *TypedDAO[T]) ListDeletedOnly(query interface{}, options ...QueryOption) ([]T, error) {
+	return t.ListDeletedOnlyContext(context.Background(), query, options...)
+}
+
+func (t *TypedDAO[T]) ListDeletedOnlyContext(ctx context.Context, query interface{}, options ...QueryOption) ([]T, error) {
+	result := []T{}
+	if err := t.dao.QueryDeletedOnlyContext(ctx, query, &result, options...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (t *TypedDAO[T]) First(query interface{}, options ...QueryOption) (*T, error) {
+	return t.FirstContext(context.Background(), query, options...)
+}
+
+// FirstContext returns the first record matching query, or gorm.ErrRecordNotFound if not found.
+// Only Order in options is used.
+func (t *TypedDAO[T]) FirstContext(ctx context.Context, query interface{}, options ...QueryOption) (*T, error) {
+	option := QueryOption{Limit: 1}
+	if len(options) > 0 {
+		option.Order = options[0].Order
+	}
+
+	result, err := t.ListContext(ctx, query, option)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &result[0], nil
+}
+
+func (t *TypedDAO[T]) Count(query interface{}) (int64, error) {
+	return t.dao.CountContext(context.Background(), query)
+}
+
+func (t *TypedDAO[T]) CountContext(ctx context.Context, query interface{}) (int64, error) {
+	return t.dao.CountContext(ctx, query)
+}
+
+func (t *TypedDAO[T]) Exists(query interface{}) (bool, error) {
+	return t.ExistsContext(context.Background(), query)
+}
+
+func (t *TypedDAO[T]) ExistsContext(ctx context.Context, query interface{}) (bool, error) {
+	count, err := t.dao.CountContext(ctx, query)
+	return count > 0, err
+}
+
+func (t *TypedDAO[T]) Create(value *T) error {
+	return t.CreateContext(context.Background(), value)
+}
+
+func (t *TypedDAO[T]) CreateContext(ctx context.Context, value *T) error {
+	model, err := toDaoModel(value)
+	if err != nil {
+		return err
+	}
+	return t.dao.CreateContext(ctx, model)
+}
+
+func (t *TypedDAO[T]) Update(query *QueryParams, value *T) error {
+	return t.UpdateContext(context.Background(), query, value)
+}
+
+func (t *TypedDAO[T]) UpdateContext(ctx context.Context, query *QueryParams, value *T) error {
+	model, err := toDaoModel(value)
+	if err != nil {
+		return err
+	}
+	return t.dao.UpdateContext(ctx, query, model)
+}
+
+func (t *TypedDAO[T]) Upsert(value *T, queryColumns []string, assignedColums []string) error {
+	return t.UpsertContext(context.Background(), value, queryColumns, assignedColums)
+}
+
+func (t *TypedDAO[T]) UpsertContext(ctx context.Context, value *T, queryColumns []string, assignedColums []string) error {
+	model, err := toDaoModel(value)
+	if err != nil {
+		return err
+	}
+	return t.dao.UpsertContext(ctx, model, queryColumns, assignedColums)
+}
+
+func (t *TypedDAO[T]) Delete(query interface{}, args ...interface{}) error {
+	return t.DeleteContext(context.Background(), query, args...)
+}
+
+func (t *TypedDAO[T]) DeleteContext(ctx context.Context, query interface{}, args ...interface{}) error {
+	return t.dao.DeleteContext(ctx, query, args...)
+}
+
+func (t *TypedDAO[T]) Restore(query interface{}, args ...interface{}) error {
+	return t.RestoreContext(context.Background(), query, args...)
+}
+
+func (t *TypedDAO[T]) RestoreContext(ctx context.Context, query interface{}, args ...interface{}) error {
+	return t.dao.RestoreContext(ctx, query, args...)
+}
+
+func (t *TypedDAO[T]) Purge(query interface{}, args ...interface{}) error {
+	return t.PurgeContext(context.Background(), query, args...)
+}
+
+func (t *TypedDAO[T]) PurgeContext(ctx context.Context, query interface{}, args ...interface{}) error {
+	return t.dao.PurgeContext(ctx, query, args...)
+}
+
+// zero value of T to create the dao. T must be the model struct, since the zero value of a pointer is nil.
+func typedModel[T DaoModel]() (T, error) {
+	var model T
+	if t := reflect.TypeOf(model); t == nil || t.Kind() == reflect.Ptr {
+		return model, logging.Errorf("TypedDAO requires a struct type instead of %v, e.g. TypedDAO[User]", t)
+	}
+	return model, nil
+}
+
+// *T implements DaoModel if T is a struct implementing it, but not if T is a pointer type
+func toDaoModel[T DaoModel](value *T) (DaoModel, error) {
+	model, ok := interface{}(value).(DaoModel)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a DaoModel", value)
+	}
+	return model, nil
+}
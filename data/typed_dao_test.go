@@ -0,0 +1,131 @@
+package data_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type TypedModel struct {
+	data.Model
+	Name   string
+	Nation string
+}
+
+func (TypedModel) TableName() string {
+	return "typed"
+}
+
+func TestTypedDAO(t *testing.T) {
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: typed.db
+        automigrate: true
+`), "database")
+	defer os.RemoveAll("typed.db")
+
+	users := data.GetTypedDAO[TypedModel](manager, "db1")
+	assert.NotNil(t, users)
+
+	user1 := &TypedModel{Name: "user1", Nation: "china"}
+	assert.Nil(t, users.Create(user1))
+	assert.Nil(t, users.Create(&TypedModel{Name: "user2", Nation: "china"}))
+	assert.Nil(t, users.Create(&TypedModel{Name: "user3", Nation: "japan"}))
+
+	found, err := users.Get(user1.UUID)
+	assert.Nil(t, err)
+	assert.Equal(t, "user1", found.Name)
+
+	_, err = users.Get("not-exist")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	list, err := users.List(&data.QueryParams{"nation": "china"}, data.QueryOption{Order: "name desc"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "user2", list[0].Name)
+
+	first, err := users.First(&data.QueryParams{"nation": "china"}, data.QueryOption{Order: "name desc"})
+	assert.Nil(t, err)
+	assert.Equal(t, "user2", first.Name)
+
+	count, err := users.Count(&data.QueryParams{"nation": "china"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	assert.Nil(t, users.Update(&data.QueryParams{"name": "user3"}, &TypedModel{Nation: "china"}))
+	exists, err := users.Exists(&data.QueryParams{"nation": "japan"})
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.Nil(t, users.Delete("name = ?", "user1"))
+	count, _ = users.Count(&data.QueryParams{})
+	assert.Equal(t, int64(2), count)
+	deleted, err := users.ListDeletedOnly(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(deleted))
+	assert.Nil(t, users.Restore("name = ?", "user1"))
+	all, _ := users.ListWithDeleted(nil)
+	assert.Equal(t, 3, len(all))
+
+	// the untyped dao is the same one registered in manager
+	assert.Equal(t, "typed", users.Untyped().Name())
+}
+
+// KeyedModel is identified by its own primary key instead of the uuid of data.Model
+type KeyedModel struct {
+	Code string `gorm:"primaryKey"`
+	Name string
+}
+
+func (KeyedModel) TableName() string {
+	return "keyed"
+}
+
+func (m KeyedModel) PrimaryID() string {
+	return m.Code
+}
+
+func TestTypedDAOModels(t *testing.T) {
+	manager := data.NewDataManager().WithConfig(config.NewConfigWithString(`
+database:
+    db1:
+        type: sqlite
+        filepath: keyed.db
+        automigrate: true
+        batchsize: 1
+`), "database")
+	defer os.RemoveAll("keyed.db")
+
+	keyed := data.GetTypedDAO[KeyedModel](manager, "db1")
+	assert.Nil(t, keyed.Create(&KeyedModel{Code: "c1", Name: "first"}))
+	found, err := keyed.Get("c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "first", found.Name)
+
+	// synced to elastic by the primary key instead of uuid
+	es := newMemoryElastic()
+	synced := data.NewDAO(manager.GetDB("db1"), &KeyedModel{})
+	synced.SetElasticClient(es)
+	assert.Nil(t, keyed.Create(&KeyedModel{Code: "c2", Name: "second"}))
+	count, err := synced.Backfill()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+	assert.Equal(t, []string{"c1", "c2"}, es.ids("sqlite_keyed"))
+	assert.Nil(t, synced.Delete(&data.QueryParams{"code": "c2"}))
+	assert.Equal(t, []string{"c1"}, es.ids("sqlite_keyed"))
+
+	// pointer types are rejected instead of panicking
+	assert.Nil(t, data.GetTypedDAO[*TypedModel](manager, "db1"))
+	assert.Nil(t, data.NewTypedDAO[*TypedModel](manager.GetDB("db1")))
+	err = manager.Transaction("db1", func(tx *data.TxScope) error {
+		_, err := data.Typed[*KeyedModel](tx)
+		return err
+	})
+	assert.NotNil(t, err)
+}
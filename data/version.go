@@ -0,0 +1,91 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Version enables optimistic locking for the model. Update only succeeds when the version in the value
+// is the same as in the database, and bumps it by 1. Records created by the DAO start with version 1.
+type Version int64
+
+// VersionedModel is Model with optimistic locking
+type VersionedModel struct {
+	Model
+	Version Version `gorm:"not null;default:1"`
+}
+
+// StaleObjectError is returned when the record was changed by others since it was read
+type StaleObjectError struct {
+	Table   string
+	Version Version
+}
+
+// ErrStaleObject matches any StaleObjectError with errors.Is
+var ErrStaleObject = &StaleObjectError{}
+
+func (e *StaleObjectError) Error() string {
+	return fmt.Sprintf("stale object in %s: version %d is not the latest", e.Table, e.Version)
+}
+
+func (e *StaleObjectError) Is(target error) bool {
+	_, ok := target.(*StaleObjectError)
+	return ok
+}
+
+// GRPCStatus makes grpc return codes.Aborted (http 409 in gateway), so the client could read and retry
+func (e *StaleObjectError) GRPCStatus() *status.Status {
+	return status.New(codes.Aborted, e.Error())
+}
+
+// version field of the value, invalid if the model is not versioned
+func (d *DAO) versionOf(value DaoModel) reflect.Value {
+	if d.versionColumn == "" {
+		return reflect.Value{}
+	}
+	v := reflect.Indirect(reflect.ValueOf(value))
+	return v.FieldByName(d.columnToField[d.versionColumn])
+}
+
+// new records start with version 1
+func (d *DAO) initVersion(value DaoModel) {
+	if version := d.versionOf(value); version.IsValid() && version.CanSet() && version.Int() == 0 {
+		version.SetInt(1)
+	}
+}
+
+// check the version read by the caller, and bump it in value, so it's updated by Updates()
+func (d *DAO) checkVersion(db *gorm.DB, value DaoModel) (*gorm.DB, Version, error) {
+	version := d.versionOf(value)
+	if !version.IsValid() {
+		return db, 0, nil
+	}
+	if !version.CanSet() {
+		return nil, 0, fmt.Errorf("versioned model %s must be updated by pointer", d.Name())
+	}
+
+	current := Version(version.Int())
+	column := clause.Column{Name: d.versionColumn}
+	if current == 0 {
+		// rows added before the version column was migrated
+		db = db.Where(clause.Or(clause.Eq{Column: column, Value: 0}, clause.Eq{Column: column, Value: nil}))
+	} else {
+		db = db.Where(clause.Eq{Column: column, Value: current})
+	}
+	version.SetInt(int64(current + 1))
+	return db, current, nil
+}
+
+// conflict assignments of Upsert for versioned models, bumping the version instead of overwriting it
+func (d *DAO) versionedAssignments(columns []string) clause.Set {
+	assignments := clause.AssignmentColumns(columns)
+	return append(assignments, clause.Assignment{
+		Column: clause.Column{Name: d.versionColumn},
+		Value:  gorm.Expr("? + 1", clause.Column{Table: d.model.TableName(), Name: d.versionColumn}),
+	})
+}
@@ -0,0 +1,79 @@
+package data_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/skema-dev/skema-go/data"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type VersionModel struct {
+	data.VersionedModel
+	Name string `gorm:"uniqueIndex"`
+	Age  int
+}
+
+func (VersionModel) TableName() string {
+	return "version"
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	dbInstance, _ := data.NewMemoryDatabase(nil)
+	dao := data.NewDAO(dbInstance, &VersionModel{})
+	dao.Automigrate()
+
+	assert.Nil(t, dao.Create(&VersionModel{Name: "user1", Age: 20}))
+	rs := []VersionModel{}
+	dao.Query(&data.QueryParams{"name": "user1"}, &rs)
+	assert.Equal(t, data.Version(1), rs[0].Version)
+
+	// two clients read the same version
+	first, second := rs[0], rs[0]
+	query := &data.QueryParams{"uuid": first.UUID}
+
+	first.Age = 21
+	assert.Nil(t, dao.Update(query, &first))
+	assert.Equal(t, data.Version(2), first.Version)
+
+	second.Age = 22
+	err := dao.Update(query, &second)
+	assert.True(t, errors.Is(err, data.ErrStaleObject))
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, data.Version(1), second.Version)
+
+	rs = []VersionModel{}
+	dao.Query(query, &rs)
+	assert.Equal(t, 21, rs[0].Age)
+	assert.Equal(t, data.Version(2), rs[0].Version)
+
+	// upsert bumps the version instead of overwriting it
+	assert.Nil(t, dao.Upsert(&VersionModel{Name: "user1", Age: 30}, []string{"name"}, []string{"age"}))
+	assert.Nil(t, dao.Upsert(&VersionModel{Name: "user1", Age: 31}, []string{"name"}, nil))
+	rs = []VersionModel{}
+	dao.Query(&data.QueryParams{"name": "user1"}, &rs)
+	assert.Equal(t, 31, rs[0].Age)
+	assert.Equal(t, data.Version(4), rs[0].Version)
+
+	assert.NotNil(t, dao.Update(query, VersionModel{Name: "user1"}))
+
+	// the version is kept when the update fails in database, so the value could be retried
+	assert.Nil(t, dao.Create(&VersionModel{Name: "user2", Age: 20}))
+	rs = []VersionModel{}
+	dao.Query(&data.QueryParams{"name": "user2"}, &rs)
+	user2 := rs[0]
+	query = &data.QueryParams{"uuid": user2.UUID}
+	user2.Name = "user1"
+	assert.NotNil(t, dao.Update(query, &user2))
+	assert.Equal(t, data.Version(1), user2.Version)
+
+	user2.Name = "user3"
+	assert.Nil(t, dao.Update(query, &user2))
+	assert.Equal(t, data.Version(2), user2.Version)
+	rs = []VersionModel{}
+	dao.Query(query, &rs)
+	assert.Equal(t, "user3", rs[0].Name)
+	assert.Equal(t, data.Version(2), rs[0].Version)
+}
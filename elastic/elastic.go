@@ -2,18 +2,64 @@ package elastic
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/skema-dev/skema-go/config"
 	"github.com/skema-dev/skema-go/logging"
+	"github.com/skema-dev/skema-go/metrics"
+	"github.com/skema-dev/skema-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// SearchResponse of SearchBody
+type SearchResponse struct {
+	// total number of matched documents
+	Total int64
+	Hits  []Hit
+	// raw aggregations by names
+	Aggregations map[string]interface{}
+}
+
+// Hit is a matched document with its score and highlights
+type Hit struct {
+	ID     string                 `json:"_id"`
+	Score  float64                `json:"_score"`
+	Source map[string]interface{} `json:"_source"`
+	// highlighted fragments by fields
+	Highlight map[string][]string `json:"highlight"`
+}
+
+// ErrNotSupported is returned for operations requiring an optional interface the client doesn't implement
+var ErrNotSupported = errors.New("not supported by the elastic client")
+
+// keep alive of the scroll context between pages of Scan
+const scrollKeepAlive = time.Minute
+
 type SearchOption struct {
 	Sort string
 	Size int
 	From int
+	// sort values of the last hit in previous page, for deep pagination instead of From
+	SearchAfter []interface{}
 }
+
+// Document to index in bulk
+type Document struct {
+	ID    string
+	Value interface{}
+}
+
+// Elastic client. The Context variants stop the request when ctx is done,
+// others are the same as calling them with context.Background()
 type Elastic interface {
 	Index(index string, id string, value interface{}) error
 	Search(index string, termQueryType string, query map[string]interface{}, option *SearchOption) ([]map[string]interface{}, error)
@@ -21,6 +67,96 @@ type Elastic interface {
 	DeleteIndex(indexes []string)
 }
 
+// ContextElastic is implemented by clients stopping index, search and delete requests when ctx is done,
+// e.g. the clients created by NewElasticClient. Use IndexContext, SearchContext and DeleteContext
+// to call them on any Elastic.
+type ContextElastic interface {
+	IndexContext(ctx context.Context, index string, id string, value interface{}) error
+	SearchContext(ctx context.Context, index string, termQueryType string, query map[string]interface{}, option *SearchOption) ([]map[string]interface{}, error)
+	DeleteContext(ctx context.Context, index string, ids []string) error
+}
+
+// IndexContext indexes the document with ctx if the client is a ContextElastic, otherwise without ctx
+func IndexContext(ctx context.Context, client Elastic, index string, id string, value interface{}) error {
+	if c, ok := client.(ContextElastic); ok {
+		return c.IndexContext(ctx, index, id, value)
+	}
+	return client.Index(index, id, value)
+}
+
+// SearchContext searches with ctx if the client is a ContextElastic, otherwise without ctx
+func SearchContext(ctx context.Context, client Elastic, index string, termQueryType string, query map[string]interface{}, option *SearchOption) ([]map[string]interface{}, error) {
+	if c, ok := client.(ContextElastic); ok {
+		return c.SearchContext(ctx, index, termQueryType, query, option)
+	}
+	return client.Search(index, termQueryType, query, option)
+}
+
+// DeleteContext deletes the documents with ctx if the client is a ContextElastic, otherwise without ctx.
+// Errors are only returned by ContextElastic.
+func DeleteContext(ctx context.Context, client Elastic, index string, ids []string) error {
+	if c, ok := client.(ContextElastic); ok {
+		return c.DeleteContext(ctx, index, ids)
+	}
+	client.Delete(index, ids)
+	return nil
+}
+
+// BulkElastic is implemented by clients indexing documents in _bulk requests, e.g. the clients created by NewElasticClient.
+// Use BulkIndexContext to index documents with any Elastic.
+type BulkElastic interface {
+	BulkIndex(index string, docs []Document) error
+	BulkIndexContext(ctx context.Context, index string, docs []Document) error
+}
+
+// BulkIndexContext indexes the documents in a _bulk request if the client is a BulkElastic,
+// otherwise one by one with IndexContext, stopping at the first failure
+func BulkIndexContext(ctx context.Context, client Elastic, index string, docs []Document) error {
+	if c, ok := client.(BulkElastic); ok {
+		return c.BulkIndexContext(ctx, index, docs)
+	}
+	for _, doc := range docs {
+		if err := IndexContext(ctx, client, index, doc.ID, doc.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexManager is implemented by clients managing indexes and aliases, e.g. the clients created by NewElasticClient.
+// Index templates, reindexing and reconciling are not supported by other clients.
+type IndexManager interface {
+	// CreateIndex with the settings and mappings in body, which could be nil
+	CreateIndex(ctx context.Context, index string, body map[string]interface{}) error
+	// Scan all documents of the index in pages of size, calling fn with each page. Nothing is scanned if the index doesn't exist.
+	Scan(ctx context.Context, index string, size int, fn func(docs []Document) error) error
+	// GetAlias returns the indexes of the alias. It returns the name itself if it's an index instead of alias,
+	// and nothing if neither exists.
+	GetAlias(ctx context.Context, alias string) ([]string, error)
+	// SwapAlias points the alias to the index only, atomically. An index with the alias name is deleted in the same request.
+	SwapAlias(ctx context.Context, alias string, index string) error
+}
+
+// BodySearcher is implemented by clients searching with the body of _search api, e.g. the clients created by NewElasticClient.
+// Full text search of DAO is not supported by other clients.
+type BodySearcher interface {
+	// SearchBody runs a search with the body of _search api, e.g. for full text queries with highlights and aggregations
+	SearchBody(ctx context.Context, index string, body map[string]interface{}) (*SearchResponse, error)
+}
+
+// Pinger is implemented by clients checking the connection to the cluster, e.g. the clients created by NewElasticClient
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping checks the connection if the client is a Pinger. Other clients can't be checked, and are taken as healthy.
+func Ping(ctx context.Context, client Elastic) error {
+	if p, ok := client.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
 func NewElasticClient(conf *config.Config) Elastic {
 	var result Elastic
 	version := conf.GetString("version", "v8")
@@ -36,6 +172,21 @@ func NewElasticClient(conf *config.Config) Elastic {
 	return result
 }
 
+// start a span for the elastic operation, ended by observe
+func startSpan(ctx context.Context, operation string, index string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "elastic."+operation,
+		semconv.DBSystemElasticsearch,
+		semconv.DBOperationKey.String(operation),
+		attribute.String("elastic.index", index),
+	)
+}
+
+// record operation latency in metrics and end the span
+func observe(operation string, start time.Time, span trace.Span, err error) {
+	metrics.ObserveElastic(operation, start, err)
+	tracing.End(span, err)
+}
+
 func createSortCondition(order string) []map[string]string {
 	result := []map[string]string{}
 
@@ -72,6 +223,12 @@ func createSortCondition(order string) []map[string]string {
 	return result
 }
 
+// query of documents by ids, matching the _id metadata field instead of a field in the source,
+// so keyword mappings of the documents are not required
+func buildDeleteQuery(ids []string) (string, error) {
+	return buildTermQuery("terms", map[string]interface{}{"_id": ids}, nil)
+}
+
 func buildTermQuery(queryType string, query map[string]interface{}, option *SearchOption) (string, error) {
 	var buf bytes.Buffer
 
@@ -103,6 +260,10 @@ func buildTermQuery(queryType string, query map[string]interface{}, option *Sear
 		if option.Size > 0 {
 			condition["size"] = option.Size
 		}
+
+		if len(option.SearchAfter) > 0 {
+			condition["search_after"] = option.SearchAfter
+		}
 	}
 
 	if err := json.NewEncoder(&buf).Encode(condition); err != nil {
@@ -112,6 +273,137 @@ func buildTermQuery(queryType string, query map[string]interface{}, option *Sear
 	return buf.String(), nil
 }
 
+// ndjson body of _bulk api, with an index action for each document
+func buildBulkBody(docs []Document) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, doc := range docs {
+		if doc.ID == "" {
+			return "", logging.Errorf("document id should not be empty")
+		}
+		action := map[string]interface{}{"index": map[string]interface{}{"_id": doc.ID}}
+		if err := encoder.Encode(action); err != nil {
+			return "", logging.Errorf(err.Error())
+		}
+		if err := encoder.Encode(doc.Value); err != nil {
+			return "", logging.Errorf(err.Error())
+		}
+	}
+	return buf.String(), nil
+}
+
+// _bulk api returns 200 even if some of the documents failed, so check the items
+func processBulkResult(body io.Reader) error {
+	res := struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}{}
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		return logging.Errorf(err.Error())
+	}
+	if !res.Errors {
+		return nil
+	}
+
+	failed := 0
+	var first error
+	for _, item := range res.Items {
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+			failed++
+			if first == nil {
+				first = fmt.Errorf("document id=%s: %s %s", result.ID, result.Error.Type, result.Error.Reason)
+			}
+		}
+	}
+	return logging.Errorf("Elasticsearch bulk indexing failed for %d of %d documents, first error %v", failed, len(res.Items), first)
+}
+
+// documents in a page of scroll, and the scroll id of the next page
+func processScrollResult(body io.Reader) (string, []Document, error) {
+	res := struct {
+		ScrollID string `json:"_scroll_id"`
+		Hits     struct {
+			Hits []struct {
+				ID     string                 `json:"_id"`
+				Source map[string]interface{} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}{}
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		return "", nil, logging.Errorf(err.Error())
+	}
+
+	docs := make([]Document, 0, len(res.Hits.Hits))
+	for _, hit := range res.Hits.Hits {
+		docs = append(docs, Document{ID: hit.ID, Value: hit.Source})
+	}
+	return res.ScrollID, docs, nil
+}
+
+// indexes in the result of get alias api: {"index1": {"aliases": {...}}}
+func processAliasResult(body io.Reader) ([]string, error) {
+	res := map[string]interface{}{}
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		return nil, logging.Errorf(err.Error())
+	}
+
+	indexes := make([]string, 0, len(res))
+	for index := range res {
+		indexes = append(indexes, index)
+	}
+	sort.Strings(indexes)
+	return indexes, nil
+}
+
+// body of _aliases api, moving the alias from the current indexes to index
+func buildAliasActions(alias string, index string, current []string) (string, error) {
+	actions := []map[string]interface{}{
+		{"add": map[string]interface{}{"index": index, "alias": alias}},
+	}
+	for _, c := range current {
+		switch c {
+		case index:
+		case alias:
+			// an index with the alias name, e.g. created before using aliases
+			actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": c}})
+		default:
+			actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": c, "alias": alias}})
+		}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return "", logging.Errorf(err.Error())
+	}
+	return string(data), nil
+}
+
+func processSearchResponse(body io.Reader) (*SearchResponse, error) {
+	res := struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []Hit `json:"hits"`
+		} `json:"hits"`
+		Aggregations map[string]interface{} `json:"aggregations"`
+	}{}
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		return nil, logging.Errorf(err.Error())
+	}
+	return &SearchResponse{Total: res.Hits.Total.Value, Hits: res.Hits.Hits, Aggregations: res.Aggregations}, nil
+}
+
 func processSearchResult(res map[string]interface{}) ([]map[string]interface{}, error) {
 	h := res["hits"].(map[string]interface{})
 	hits := h["hits"].([]interface{})
@@ -1,6 +1,8 @@
 package elastic
 
 import (
+	"context"
+	"strings"
 	"testing"
 
 	"github.com/skema-dev/skema-go/logging"
@@ -53,3 +55,120 @@ func TestCreateOrderCondition(t *testing.T) {
 	assert.Equal(t, "desc", sort[0]["id"])
 	assert.Equal(t, "desc", sort[1]["name"])
 }
+
+func TestDeleteQuery(t *testing.T) {
+	query, err := buildDeleteQuery([]string{"1", "2"})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"query":{"terms":{"_id":["1","2"]}}}`, query)
+}
+
+func TestBulkIndex(t *testing.T) {
+	body, err := buildBulkBody([]Document{
+		{ID: "1", Value: map[string]interface{}{"Name": "user1"}},
+		{ID: "2", Value: map[string]interface{}{"Name": "user2"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"index":{"_id":"1"}}
+{"Name":"user1"}
+{"index":{"_id":"2"}}
+{"Name":"user2"}
+`, body)
+
+	_, err = buildBulkBody([]Document{{Value: "no id"}})
+	assert.NotNil(t, err)
+
+	assert.Nil(t, processBulkResult(strings.NewReader(`{"errors":false,"items":[{"index":{"_id":"1","status":201}}]}`)))
+	err = processBulkResult(strings.NewReader(`{"errors":true,"items":[
+		{"index":{"_id":"1","status":201}},
+		{"index":{"_id":"2","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}
+	]}`))
+	assert.Contains(t, err.Error(), "1 of 2")
+	assert.Contains(t, err.Error(), "id=2: mapper_parsing_exception")
+}
+
+func TestScrollAndAlias(t *testing.T) {
+	scrollID, docs, err := processScrollResult(strings.NewReader(`{"_scroll_id":"scroll1","hits":{"hits":[
+		{"_id":"1","_source":{"Name":"user1"}},
+		{"_id":"2","_source":{"Name":"user2"}}
+	]}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "scroll1", scrollID)
+	assert.Equal(t, []Document{
+		{ID: "1", Value: map[string]interface{}{"Name": "user1"}},
+		{ID: "2", Value: map[string]interface{}{"Name": "user2"}},
+	}, docs)
+
+	indexes, err := processAliasResult(strings.NewReader(`{"users_2":{"aliases":{"users":{}}},"users_1":{"aliases":{"users":{}}}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users_1", "users_2"}, indexes)
+
+	body, err := buildAliasActions("users", "users_3", []string{"users", "users_2", "users_3"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"actions":[{"add":{"alias":"users","index":"users_3"}},{"remove_index":{"index":"users"}},{"remove":{"alias":"users","index":"users_2"}}]}`, body)
+}
+
+func TestSearchResponse(t *testing.T) {
+	res, err := processSearchResponse(strings.NewReader(`{
+		"hits":{"total":{"value":12,"relation":"eq"},"max_score":1.5,"hits":[
+			{"_id":"1","_score":1.5,"_source":{"Title":"hello world"},"highlight":{"Title":["<em>hello</em> world"]}}
+		]},
+		"aggregations":{"Nation":{"buckets":[{"key":"china","doc_count":10}]}}
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), res.Total)
+	assert.Equal(t, []Hit{{
+		ID:        "1",
+		Score:     1.5,
+		Source:    map[string]interface{}{"Title": "hello world"},
+		Highlight: map[string][]string{"Title": {"<em>hello</em> world"}},
+	}}, res.Hits)
+	assert.Contains(t, res.Aggregations, "Nation")
+}
+
+// plainElastic implements Elastic without the context methods
+type plainElastic struct {
+	Elastic
+	calls []string
+}
+
+func (e *plainElastic) Index(index string, id string, value interface{}) error {
+	e.calls = append(e.calls, "index "+id)
+	return nil
+}
+
+func (e *plainElastic) Search(index string, termQueryType string, query map[string]interface{}, option *SearchOption) ([]map[string]interface{}, error) {
+	e.calls = append(e.calls, "search")
+	return nil, nil
+}
+
+func (e *plainElastic) Delete(index string, ids []string) {
+	e.calls = append(e.calls, "delete "+strings.Join(ids, ","))
+}
+
+func TestContextFallback(t *testing.T) {
+	var client Elastic = &plainElastic{}
+	_, ok := client.(ContextElastic)
+	assert.False(t, ok)
+
+	ctx := context.Background()
+	assert.Nil(t, IndexContext(ctx, client, "test", "1", map[string]interface{}{}))
+	_, err := SearchContext(ctx, client, "test", "bool", nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, DeleteContext(ctx, client, "test", []string{"1", "2"}))
+	assert.Equal(t, []string{"index 1", "search", "delete 1,2"}, client.(*plainElastic).calls)
+	assert.Nil(t, BulkIndexContext(ctx, client, "test", []Document{{ID: "3"}, {ID: "4"}}))
+	assert.Equal(t, []string{"index 1", "search", "delete 1,2", "index 3", "index 4"}, client.(*plainElastic).calls)
+	// clients without Ping are taken as healthy
+	assert.Nil(t, Ping(ctx, client))
+
+	var _ ContextElastic = &elasticClientV7{}
+	var _ ContextElastic = &elasticClientV8{}
+	var _ BulkElastic = &elasticClientV7{}
+	var _ BulkElastic = &elasticClientV8{}
+	var _ Pinger = &elasticClientV7{}
+	var _ Pinger = &elasticClientV8{}
+	var _ IndexManager = &elasticClientV7{}
+	var _ IndexManager = &elasticClientV8{}
+	var _ BodySearcher = &elasticClientV7{}
+	var _ BodySearcher = &elasticClientV8{}
+}
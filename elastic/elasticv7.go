@@ -1,10 +1,15 @@
 package elastic
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"strings"
+	"time"
 
 	es "github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
@@ -58,12 +63,19 @@ func newElasticClientV7(conf *config.Config) *elasticClientV7 {
 }
 
 func (e *elasticClientV7) Index(index string, id string, value interface{}) error {
+	return e.IndexContext(context.Background(), index, id, value)
+}
+
+func (e *elasticClientV7) IndexContext(ctx context.Context, index string, id string, value interface{}) (err error) {
+	ctx, span := startSpan(ctx, "index", index)
+	defer func(start time.Time) { observe("index", start, span, err) }(time.Now())
+
 	if index == "" || id == "" {
 		return logging.Errorf("index and id should not be empty. index: %s, id: %s", index, id)
 	}
-	data, err := json.Marshal(value)
-	if err != nil {
-		return logging.Errorf(err.Error())
+	data, marshalErr := json.Marshal(value)
+	if marshalErr != nil {
+		return logging.Errorf(marshalErr.Error())
 	}
 
 	s := string(data)
@@ -76,7 +88,7 @@ func (e *elasticClientV7) Index(index string, id string, value interface{}) erro
 		Refresh:    "true",
 	}
 
-	res, err := req.Do(context.Background(), e.client)
+	res, err := req.Do(ctx, e.client)
 	if err != nil {
 		return logging.Errorf(err.Error())
 	}
@@ -89,7 +101,54 @@ func (e *elasticClientV7) Index(index string, id string, value interface{}) erro
 	return nil
 }
 
+func (e *elasticClientV7) BulkIndex(index string, docs []Document) error {
+	return e.BulkIndexContext(context.Background(), index, docs)
+}
+
+// BulkIndexContext indexes all docs in one _bulk request, refreshing the index once
+func (e *elasticClientV7) BulkIndexContext(ctx context.Context, index string, docs []Document) (err error) {
+	ctx, span := startSpan(ctx, "bulk", index)
+	defer func(start time.Time) { observe("bulk", start, span, err) }(time.Now())
+
+	if index == "" {
+		return logging.Errorf("index should not be empty")
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	body, err := buildBulkBody(docs)
+	if err != nil {
+		return err
+	}
+	logging.Debugw("elastic bulk request", "index", index, "count", len(docs))
+
+	req := esapi.BulkRequest{
+		Index:   index,
+		Body:    strings.NewReader(body),
+		Refresh: "true",
+	}
+
+	res, err := req.Do(ctx, e.client)
+	if err != nil {
+		return logging.Errorf(err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return logging.Errorf("Elasticsearch bulk error for index %s: %s", index, res.Status())
+	}
+
+	return processBulkResult(res.Body)
+}
+
 func (e *elasticClientV7) Search(index string, termQueryType string, query map[string]interface{}, option *SearchOption) ([]map[string]interface{}, error) {
+	return e.SearchContext(context.Background(), index, termQueryType, query, option)
+}
+
+func (e *elasticClientV7) SearchContext(ctx context.Context, index string, termQueryType string, query map[string]interface{}, option *SearchOption) (result []map[string]interface{}, err error) {
+	ctx, span := startSpan(ctx, "search", index)
+	defer func(start time.Time) { observe("search", start, span, err) }(time.Now())
+
 	searchQuery, err := buildTermQuery(termQueryType, query, option)
 	if err != nil {
 		return nil, logging.Errorf(err.Error())
@@ -98,7 +157,7 @@ func (e *elasticClientV7) Search(index string, termQueryType string, query map[s
 	logging.Debugf("Search Query: %s", searchQuery)
 
 	res, err := e.client.Search(
-		e.client.Search.WithContext(context.Background()),
+		e.client.Search.WithContext(ctx),
 		e.client.Search.WithIndex(index),
 		e.client.Search.WithBody(strings.NewReader(searchQuery)),
 		e.client.Search.WithTrackTotalHits(true),
@@ -121,13 +180,29 @@ func (e *elasticClientV7) Search(index string, termQueryType string, query map[s
 }
 
 func (e *elasticClientV7) Delete(index string, ids []string) {
-	searchQuery, err := buildTermQuery("terms", map[string]interface{}{"id": ids}, nil)
+	e.DeleteContext(context.Background(), index, ids)
+}
+
+func (e *elasticClientV7) DeleteContext(ctx context.Context, index string, ids []string) (err error) {
+	ctx, span := startSpan(ctx, "delete", index)
+	defer func(start time.Time) { observe("delete", start, span, err) }(time.Now())
+
+	searchQuery, err := buildDeleteQuery(ids)
+	if err != nil {
+		return err
+	}
 	logging.Debugw("Delete es docs", "index", index, "ids", ids)
-	_, err = e.client.DeleteByQuery([]string{index}, strings.NewReader(searchQuery))
+
+	res, err := e.client.DeleteByQuery([]string{index}, strings.NewReader(searchQuery), e.client.DeleteByQuery.WithContext(ctx))
 	if err != nil {
-		logging.Errorf("failded to deletes: %s", err.Error())
-		return
+		return logging.Errorf("failded to deletes: %s", err.Error())
 	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return logging.Errorf("Elasticsearch delete error for index %s: %s", index, res.Status())
+	}
+	return nil
 }
 
 func (e *elasticClientV7) DeleteIndex(indexes []string) {
@@ -143,3 +218,185 @@ func (e *elasticClientV7) DeleteIndex(indexes []string) {
 
 	logging.Debugf("index deleted %d", len(indexes))
 }
+
+func (e *elasticClientV7) Ping(ctx context.Context) error {
+	if e == nil || e.client == nil {
+		return errors.New("elastic client is not initialized")
+	}
+
+	res, err := e.client.Ping(e.client.Ping.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("elastic ping failed: %s", res.Status())
+	}
+	return nil
+}
+
+func (e *elasticClientV7) CreateIndex(ctx context.Context, index string, body map[string]interface{}) (err error) {
+	ctx, span := startSpan(ctx, "create_index", index)
+	defer func(start time.Time) { observe("create_index", start, span, err) }(time.Now())
+
+	opts := []func(*esapi.IndicesCreateRequest){e.client.Indices.Create.WithContext(ctx)}
+	if body != nil {
+		data, marshalErr := json.Marshal(body)
+		if marshalErr != nil {
+			return logging.Errorf(marshalErr.Error())
+		}
+		opts = append(opts, e.client.Indices.Create.WithBody(bytes.NewReader(data)))
+	}
+
+	res, err := e.client.Indices.Create(index, opts...)
+	if err != nil {
+		return logging.Errorf(err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return logging.Errorf("Elasticsearch create index error for index %s: %s", index, res.String())
+	}
+	return nil
+}
+
+func (e *elasticClientV7) Scan(ctx context.Context, index string, size int, fn func(docs []Document) error) (err error) {
+	ctx, span := startSpan(ctx, "scan", index)
+	defer func(start time.Time) { observe("scan", start, span, err) }(time.Now())
+
+	if size <= 0 {
+		size = 1000
+	}
+	scrollID := ""
+	defer func() {
+		if scrollID != "" {
+			if res, err := e.client.ClearScroll(e.client.ClearScroll.WithScrollID(scrollID)); err == nil {
+				res.Body.Close()
+			}
+		}
+	}()
+
+	res, err := e.client.Search(
+		e.client.Search.WithContext(ctx),
+		e.client.Search.WithIndex(index),
+		e.client.Search.WithScroll(scrollKeepAlive),
+		e.client.Search.WithSize(size),
+		e.client.Search.WithSort("_doc"),
+	)
+	for {
+		if err != nil {
+			return logging.Errorf(err.Error())
+		}
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			return nil
+		}
+		if res.IsError() {
+			res.Body.Close()
+			return logging.Errorf("Elasticsearch scan error for index %s: %s", index, res.Status())
+		}
+
+		next, docs, err := processScrollResult(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if next != "" {
+			scrollID = next
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		if err = fn(docs); err != nil {
+			return err
+		}
+
+		res, err = e.client.Scroll(
+			e.client.Scroll.WithContext(ctx),
+			e.client.Scroll.WithScrollID(scrollID),
+			e.client.Scroll.WithScroll(scrollKeepAlive),
+		)
+	}
+}
+
+func (e *elasticClientV7) GetAlias(ctx context.Context, alias string) (indexes []string, err error) {
+	ctx, span := startSpan(ctx, "get_alias", alias)
+	defer func(start time.Time) { observe("get_alias", start, span, err) }(time.Now())
+
+	res, err := e.client.Indices.GetAlias(e.client.Indices.GetAlias.WithContext(ctx), e.client.Indices.GetAlias.WithName(alias))
+	if err != nil {
+		return nil, logging.Errorf(err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		// not an alias, but it could be an index
+		exists, err := e.client.Indices.Exists([]string{alias}, e.client.Indices.Exists.WithContext(ctx))
+		if err != nil {
+			return nil, logging.Errorf(err.Error())
+		}
+		defer exists.Body.Close()
+
+		if exists.StatusCode == http.StatusOK {
+			return []string{alias}, nil
+		}
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, logging.Errorf("Elasticsearch get alias error for %s: %s", alias, res.Status())
+	}
+	return processAliasResult(res.Body)
+}
+
+func (e *elasticClientV7) SwapAlias(ctx context.Context, alias string, index string) (err error) {
+	ctx, span := startSpan(ctx, "swap_alias", alias)
+	defer func(start time.Time) { observe("swap_alias", start, span, err) }(time.Now())
+
+	current, err := e.GetAlias(ctx, alias)
+	if err != nil {
+		return err
+	}
+	body, err := buildAliasActions(alias, index, current)
+	if err != nil {
+		return err
+	}
+	logging.Debugw("elastic swap alias", "alias", alias, "index", index, "current", current)
+
+	res, err := e.client.Indices.UpdateAliases(strings.NewReader(body), e.client.Indices.UpdateAliases.WithContext(ctx))
+	if err != nil {
+		return logging.Errorf(err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return logging.Errorf("Elasticsearch swap alias error for %s: %s", alias, res.String())
+	}
+	return nil
+}
+
+func (e *elasticClientV7) SearchBody(ctx context.Context, index string, body map[string]interface{}) (result *SearchResponse, err error) {
+	ctx, span := startSpan(ctx, "search", index)
+	defer func(start time.Time) { observe("search", start, span, err) }(time.Now())
+
+	data, marshalErr := json.Marshal(body)
+	if marshalErr != nil {
+		return nil, logging.Errorf(marshalErr.Error())
+	}
+	logging.Debugw("elastic search request", "index", index, "body", string(data))
+
+	res, err := e.client.Search(
+		e.client.Search.WithContext(ctx),
+		e.client.Search.WithIndex(index),
+		e.client.Search.WithBody(bytes.NewReader(data)),
+	)
+	if err != nil {
+		return nil, logging.Errorf(err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, logging.Errorf("Elasticsearch search error for index %s: %s", index, res.String())
+	}
+	return processSearchResponse(res.Body)
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/skema-dev/skema-go/config"
 	"github.com/skema-dev/skema-go/logging"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 type ClientConn struct {
@@ -38,10 +37,17 @@ func (cc *ClientConn) NewClientConnWithConfig(config *config.Config) (grpc.Clien
 	if config.GetString("client.address") == "" {
 		logging.Fatalf("Can not get client address in config file, please check!")
 	}
+	tlsConfig, err := loadClientTLSConfig(config.GetSubConfig("client.tls"))
+	if err != nil {
+		return nil, logging.Errorf("invalid client tls config: %s", err.Error())
+	}
 	cc.mux.Lock()
 	cc.mux.Unlock()
-	conn, err := grpc.DialContext(cc.ctx, config.GetString("client.address"),
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(transportCredentials(tlsConfig))}
+	if config.GetSubConfig("tracing") != nil {
+		dialOptions = append(dialOptions, tracingDialOptions()...)
+	}
+	conn, err := grpc.DialContext(cc.ctx, config.GetString("client.address"), dialOptions...)
 	if err != nil {
 		logging.Fatalf("Did not connect: %v", err)
 		defer cc.cancel()
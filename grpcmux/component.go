@@ -3,10 +3,12 @@ package grpcmux
 import (
 	"github.com/skema-dev/skema-go/config"
 	"github.com/skema-dev/skema-go/logging"
+	"github.com/skema-dev/skema-go/tracing"
 )
 
 func initComponents(conf *config.Config) {
 	initLogging(conf.GetSubConfig("logging"))
+	initTracing(conf.GetSubConfig("tracing"))
 }
 
 func initLogging(conf *config.Config) {
@@ -21,3 +23,13 @@ func initLogging(conf *config.Config) {
 	logging.Infow("logging initialized:", "level", level, "encoding", encoding)
 	logging.Init(level, encoding, outputPath)
 }
+
+func initTracing(conf *config.Config) {
+	if conf == nil {
+		return
+	}
+
+	if err := tracing.Init(conf); err != nil {
+		logging.Fatalf("invalid tracing config: %s", err.Error())
+	}
+}
@@ -3,6 +3,7 @@ package grpcmux
 import (
 	"context"
 	"fmt"
+	"io"
 
 	"google.golang.org/grpc"
 )
@@ -26,3 +27,11 @@ func (c *gatewayClient) NewStream(ctx context.Context, desc *grpc.StreamDesc, me
 	}
 	return c.connection.NewStream(ctx, desc, method, opts...)
 }
+
+// Close the underlying connection if it's closable
+func (c *gatewayClient) Close() error {
+	if closer, ok := c.connection.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package grpcmux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/skema-dev/skema-go/health"
+	"github.com/skema-dev/skema-go/logging"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// grpc.health.v1 is registered when creating the server.
+// serveHealth adds the http probes and keeps the grpc serving status updated. e.g.
+//
+//	health:
+//	  liveness: /healthz    # http liveness path
+//	  readiness: /readyz    # http readiness path
+//	  interval: 10s         # how often the grpc serving status is refreshed from the checkers
+//	  timeout: 3s           # timeout for running all checkers
+func (g *grpcServer) serveHealth() {
+	if g.httpPort > 0 {
+		livenessPath := g.conf.GetString("health.liveness", "/healthz")
+		readinessPath := g.conf.GetString("health.readiness", "/readyz")
+		g.httpMux.HandleFunc(livenessPath, g.handleLiveness)
+		g.httpMux.HandleFunc(readinessPath, g.handleReadiness)
+		logging.Infow("health check path", "liveness", livenessPath, "readiness", readinessPath)
+	}
+
+	go g.watchHealth()
+}
+
+func (g *grpcServer) healthCheckTimeout() time.Duration {
+	timeout, err := time.ParseDuration(g.conf.GetString("health.timeout", "3s"))
+	if err != nil {
+		logging.Errorf("invalid health.timeout: %s", err.Error())
+		return 3 * time.Second
+	}
+	return timeout
+}
+
+// refresh the grpc serving status from registered checkers until the server stops
+func (g *grpcServer) watchHealth() {
+	interval, err := time.ParseDuration(g.conf.GetString("health.interval", "10s"))
+	if err != nil || interval <= 0 {
+		logging.Errorf("invalid health.interval: %s", g.conf.GetString("health.interval"))
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(g.ctx, g.healthCheckTimeout())
+		ready := health.Ready(ctx)
+		cancel()
+
+		if g.ctx.Err() != nil {
+			return
+		}
+
+		servingStatus := healthpb.HealthCheckResponse_SERVING
+		if !ready {
+			servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		g.healthServer.SetServingStatus("", servingStatus)
+
+		select {
+		case <-g.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *grpcServer) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readinessResponse{Status: "ok"})
+}
+
+func (g *grpcServer) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	result := readinessResponse{Status: "ok", Checks: map[string]string{}}
+	statusCode := http.StatusOK
+
+	if g.isStopping() {
+		result.Status = "stopping"
+		statusCode = http.StatusServiceUnavailable
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), g.healthCheckTimeout())
+		defer cancel()
+
+		for name, err := range health.CheckAll(ctx) {
+			if err != nil {
+				result.Checks[name] = err.Error()
+				result.Status = "unavailable"
+				statusCode = http.StatusServiceUnavailable
+			} else {
+				result.Checks[name] = "ok"
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(result)
+}
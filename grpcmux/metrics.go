@@ -0,0 +1,78 @@
+package grpcmux
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type routeHolderKey struct{}
+
+// response writer keeping the status code for metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// metrics middleware is enabled automatically as the outermost one when metrics is defined in config:
+//
+//	metrics:
+//	  path: /metrics
+func newMetricsMiddleware(conf *config.Config) (*Middleware, error) {
+	return &Middleware{
+		Unary: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			metrics.ObserveRPC(info.FullMethod, status.Code(err).String(), start)
+			return resp, err
+		},
+		Stream: func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+			start := time.Now()
+			err := handler(srv, ss)
+			metrics.ObserveRPC(info.FullMethod, status.Code(err).String(), start)
+			return err
+		},
+		HTTP: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				start := time.Now()
+				// the route pattern is filled by the gateway when a route is matched,
+				// so the metrics won't explode with raw url paths
+				route := "unmatched"
+				recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), routeHolderKey{}, &route)))
+				metrics.ObserveHTTP(r.Method, route, strconv.Itoa(recorder.status), start)
+			})
+		},
+		GatewayOptions: []runtime.ServeMuxOption{
+			runtime.WithMetadata(func(ctx context.Context, r *http.Request) metadata.MD {
+				if holder, ok := r.Context().Value(routeHolderKey{}).(*string); ok {
+					if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+						*holder = pattern
+					}
+				}
+				return nil
+			}),
+		},
+	}, nil
+}
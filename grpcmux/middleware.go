@@ -0,0 +1,266 @@
+package grpcmux
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	requestIDKey    = "x-request-id"
+	requestIDHeader = "X-Request-Id"
+)
+
+// Middleware wraps grpc calls and gateway http requests with the same behavior.
+// Any of the fields could be nil if not needed
+type Middleware struct {
+	Unary          grpc.UnaryServerInterceptor
+	Stream         grpc.StreamServerInterceptor
+	HTTP           func(http.Handler) http.Handler
+	GatewayOptions []runtime.ServeMuxOption
+}
+
+// MiddlewareFactory creates a middleware with its config in grpc.yaml. conf is nil if no config defined
+type MiddlewareFactory func(conf *config.Config) (*Middleware, error)
+
+type requestIDContextKey struct{}
+
+var (
+	middlewareCreateMap = map[string]MiddlewareFactory{
+		"recovery":   newRecoveryMiddleware,
+		"logging":    newLoggingMiddleware,
+		"requestid":  newRequestIDMiddleware,
+		"timeout":    newTimeoutMiddleware,
+		"validation": newValidationMiddleware,
+	}
+)
+
+// RegisterMiddleware so it can be enabled by name in grpc.yaml.
+// Existing middleware with the same name will be overwritten.
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	if _, ok := middlewareCreateMap[name]; ok {
+		logging.Warnw("middleware already exists and will be overwritten.", "name", name)
+	}
+	middlewareCreateMap[name] = factory
+}
+
+// load middlewares in the order defined in config:
+//
+// middlewares:
+//   - recovery:
+//   - requestid:
+//   - logging:
+//   - timeout:
+//       duration: 5s
+//   - validation:
+func loadMiddlewares(conf *config.Config) ([]*Middleware, error) {
+	result := []*Middleware{}
+	for _, item := range conf.GetArrayItems("middlewares") {
+		createFn, ok := middlewareCreateMap[item.Key]
+		if !ok {
+			return nil, fmt.Errorf("middleware %s is not supported", item.Key)
+		}
+
+		m, err := createFn(item.Config)
+		if err != nil {
+			return nil, fmt.Errorf("failed creating middleware %s: %w", item.Key, err)
+		}
+		result = append(result, m)
+		logging.Infow("middleware enabled", "name", item.Key)
+	}
+
+	return result, nil
+}
+
+// RequestIDFromContext returns the request id generated by requestid middleware
+func RequestIDFromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(requestIDContextKey{}).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// server stream with a replaced context
+type wrappedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedServerStream) Context() context.Context {
+	return w.ctx
+}
+
+func newRecoveryMiddleware(conf *config.Config) (*Middleware, error) {
+	recoverToError := func(method string, r interface{}) error {
+		logging.Errorw("panic recovered", "method", method, "panic", r, "stack", string(debug.Stack()))
+		return status.Errorf(codes.Internal, "internal error")
+	}
+
+	return &Middleware{
+		Unary: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (resp interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = recoverToError(info.FullMethod, r)
+				}
+			}()
+			return handler(ctx, req)
+		},
+		Stream: func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = recoverToError(info.FullMethod, r)
+				}
+			}()
+			return handler(srv, ss)
+		},
+		HTTP: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer func() {
+					if v := recover(); v != nil {
+						recoverToError(r.URL.Path, v)
+						http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					}
+				}()
+				next.ServeHTTP(w, r)
+			})
+		},
+	}, nil
+}
+
+func newLoggingMiddleware(conf *config.Config) (*Middleware, error) {
+	logRequest := func(ctx context.Context, method string, start time.Time, err error) {
+		logging.Infow("grpc request",
+			"method", method,
+			"code", status.Code(err).String(),
+			"duration", time.Since(start).String(),
+			"request id", RequestIDFromContext(ctx),
+		)
+	}
+
+	return &Middleware{
+		Unary: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			logRequest(ctx, info.FullMethod, start, err)
+			return resp, err
+		},
+		Stream: func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+			start := time.Now()
+			err := handler(srv, ss)
+			logRequest(ss.Context(), info.FullMethod, start, err)
+			return err
+		},
+		HTTP: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				start := time.Now()
+				next.ServeHTTP(w, r)
+				logging.Infow("http request",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"duration", time.Since(start).String(),
+					"request id", r.Header.Get(requestIDHeader),
+				)
+			})
+		},
+	}, nil
+}
+
+func newRequestIDMiddleware(conf *config.Config) (*Middleware, error) {
+	withRequestID := func(ctx context.Context) context.Context {
+		id := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(requestIDKey); len(values) > 0 {
+				id = values[0]
+			}
+		}
+		if id == "" {
+			id = uuid.New().String()
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+		return context.WithValue(ctx, requestIDContextKey{}, id)
+	}
+
+	return &Middleware{
+		Unary: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(withRequestID(ctx), req)
+		},
+		Stream: func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+			return handler(srv, &wrappedServerStream{ServerStream: ss, ctx: withRequestID(ss.Context())})
+		},
+		HTTP: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get(requestIDHeader) == "" {
+					r.Header.Set(requestIDHeader, uuid.New().String())
+				}
+				w.Header().Set(requestIDHeader, r.Header.Get(requestIDHeader))
+				next.ServeHTTP(w, r)
+			})
+		},
+		GatewayOptions: []runtime.ServeMuxOption{
+			// forward the request id header to grpc metadata
+			runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
+				if http.CanonicalHeaderKey(key) == requestIDHeader {
+					return requestIDKey, true
+				}
+				return runtime.DefaultHeaderMatcher(key)
+			}),
+		},
+	}, nil
+}
+
+func newTimeoutMiddleware(conf *config.Config) (*Middleware, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("duration must be defined for timeout")
+	}
+	timeout, err := time.ParseDuration(conf.GetString("duration"))
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the original deadline if it's shorter
+	withTimeout := func(ctx context.Context) (context.Context, context.CancelFunc) {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+			return context.WithCancel(ctx)
+		}
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return &Middleware{
+		Unary: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, cancel := withTimeout(ctx)
+			defer cancel()
+			return handler(ctx, req)
+		},
+		Stream: func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+			ctx, cancel := withTimeout(ss.Context())
+			defer cancel()
+			return handler(srv, &wrappedServerStream{ServerStream: ss, ctx: ctx})
+		},
+		HTTP: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx, cancel := withTimeout(r.Context())
+				defer cancel()
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		},
+	}, nil
+}
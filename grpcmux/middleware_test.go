@@ -0,0 +1,161 @@
+package grpcmux
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testUnaryInfo = &grpc.UnaryServerInfo{FullMethod: "/test.Test/Helloworld"}
+
+// call the unary interceptors of the middlewares in order, the first one is the outermost
+func callUnary(middlewares []*Middleware, ctx context.Context, handler grpc.UnaryHandler) (interface{}, error) {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		interceptor, next := middlewares[i].Unary, handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, req, testUnaryInfo, next)
+		}
+	}
+	return handler(ctx, "request")
+}
+
+func TestLoadMiddlewares(t *testing.T) {
+	called := []string{}
+	recordingFactory := func(name string) MiddlewareFactory {
+		return func(conf *config.Config) (*Middleware, error) {
+			return &Middleware{Unary: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+				handler grpc.UnaryHandler) (interface{}, error) {
+				called = append(called, name)
+				return handler(ctx, req)
+			}}, nil
+		}
+	}
+	RegisterMiddleware("first", recordingFactory("first"))
+	RegisterMiddleware("second", recordingFactory("second"))
+	defer func() {
+		delete(middlewareCreateMap, "first")
+		delete(middlewareCreateMap, "second")
+	}()
+
+	middlewares, err := loadMiddlewares(config.NewConfigWithString(`
+middlewares:
+  - second:
+  - recovery:
+  - first:
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(middlewares))
+	_, err = callUnary(middlewares, context.Background(), func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"second", "first"}, called)
+
+	middlewares, err = loadMiddlewares(config.NewConfigWithString("port: 9991"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(middlewares))
+
+	_, err = loadMiddlewares(config.NewConfigWithString(`
+middlewares:
+  - recovery:
+  - unknown:
+`))
+	assert.NotNil(t, err)
+
+	// timeout without duration
+	_, err = loadMiddlewares(config.NewConfigWithString(`
+middlewares:
+  - timeout:
+`))
+	assert.NotNil(t, err)
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	m, err := newRecoveryMiddleware(nil)
+	assert.Nil(t, err)
+
+	_, err = callUnary([]*Middleware{m}, context.Background(), func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	err = m.Stream(nil, nil, &grpc.StreamServerInfo{FullMethod: "/test.Test/Stream"},
+		func(srv interface{}, stream grpc.ServerStream) error {
+			panic("boom")
+		})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	recorder := httptest.NewRecorder()
+	m.HTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/hello", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	m, err := newRequestIDMiddleware(nil)
+	assert.Nil(t, err)
+
+	requestID := ""
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		requestID = RequestIDFromContext(ctx)
+		return req, nil
+	}
+
+	// propagated from the incoming metadata
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIDKey, "request-1"))
+	_, err = callUnary([]*Middleware{m}, ctx, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "request-1", requestID)
+
+	// generated if not defined
+	_, err = callUnary([]*Middleware{m}, context.Background(), handler)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", requestID)
+	assert.NotEqual(t, "request-1", requestID)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/api/hello", nil)
+	request.Header.Set(requestIDHeader, "request-2")
+	m.HTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(recorder, request)
+	assert.Equal(t, "request-2", recorder.Header().Get(requestIDHeader))
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	m, err := newTimeoutMiddleware(config.NewConfigWithString("duration: 5s"))
+	assert.Nil(t, err)
+
+	var deadline time.Time
+	hasDeadline := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		deadline, hasDeadline = ctx.Deadline()
+		return req, nil
+	}
+
+	_, err = callUnary([]*Middleware{m}, context.Background(), handler)
+	assert.Nil(t, err)
+	assert.True(t, hasDeadline)
+	assert.WithinDuration(t, time.Now().Add(5*time.Second), deadline, time.Second)
+
+	// a shorter deadline of the caller is kept
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	expected, _ := ctx.Deadline()
+	_, err = callUnary([]*Middleware{m}, ctx, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, deadline)
+
+	_, err = newTimeoutMiddleware(nil)
+	assert.NotNil(t, err)
+	_, err = newTimeoutMiddleware(config.NewConfigWithString("duration: soon"))
+	assert.NotNil(t, err)
+}
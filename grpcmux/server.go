@@ -6,19 +6,31 @@ import (
 	"fmt"
 	"github.com/skema-dev/skema-go/config"
 	"github.com/skema-dev/skema-go/logging"
+	"github.com/skema-dev/skema-go/metrics"
+	"github.com/skema-dev/skema-go/tracing"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
+// time to flush traces when the shutdown ctx is done already
+const traceFlushTimeout = 5 * time.Second
+
 var (
 	configSearchPaths = []string{"./grpc.yaml", "./config/grpc.yaml", "/config/grpc.yaml", "./grpc.yml", "./config/grpc.yml", "/config/grpc.yml"}
 )
@@ -29,14 +41,23 @@ type grpcServer struct {
 	port   int
 
 	httpPort         int
+	httpServer       *http.Server
 	httpMux          *http.ServeMux
 	gatewayMux       *runtime.ServeMux
 	gatewayRoutePath string
 
-	clientConn *gatewayClient
+	clientConn      *gatewayClient
+	httpMiddlewares []func(http.Handler) http.Handler
+	healthServer    *grpchealth.Server
 
 	ctx        context.Context
 	cancelFunc context.CancelFunc
+
+	shutdownTimeout time.Duration
+	stopOnce        sync.Once
+	stopping        int32
+	// closed when the whole shutdown sequence completes
+	stopped chan struct{}
 }
 
 func NewServer(opts ...grpc.ServerOption) *grpcServer {
@@ -62,11 +83,29 @@ func NewServerWithConfig(conf *config.Config, opts ...grpc.ServerOption) *grpcSe
 		logging.Fatalf("duplicated url path found. please fix the grpc config file")
 	}
 
+	serverTLS, err := loadServerTLSConfig(conf.GetSubConfig("tls"))
+	if err != nil {
+		logging.Fatalf("invalid tls config: %s", err.Error())
+	}
+	loopbackTLS, err := loadLoopbackTLSConfig(conf.GetSubConfig("tls"))
+	if err != nil {
+		logging.Fatalf("invalid tls config: %s", err.Error())
+	}
+	httpTLS, err := loadHTTPTLSConfig(conf, serverTLS)
+	if err != nil {
+		logging.Fatalf("invalid http tls config: %s", err.Error())
+	}
+
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(transportCredentials(loopbackTLS))}
+	if conf.GetSubConfig("tracing") != nil {
+		dialOptions = append(dialOptions, tracingDialOptions()...)
+	}
+
 	// connect to grpc port
 	conn, err := grpc.DialContext(
 		context.Background(),
 		"localhost"+fmt.Sprintf(":%d", port),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		dialOptions...,
 	)
 	if err != nil {
 		logging.Errorf("Failed to create connection for localhost:%d: %s", port, err.Error())
@@ -74,9 +113,44 @@ func NewServerWithConfig(conf *config.Config, opts ...grpc.ServerOption) *grpcSe
 		return nil
 	}
 
-	serverMux := runtime.NewServeMux(
+	middlewares, err := loadMiddlewares(conf)
+	if err != nil {
+		logging.Fatalf("invalid middlewares config: %s", err.Error())
+	}
+	if conf.GetSubConfig("metrics") != nil {
+		metricsMiddleware, _ := newMetricsMiddleware(conf.GetSubConfig("metrics"))
+		middlewares = append([]*Middleware{metricsMiddleware}, middlewares...)
+	}
+	if conf.GetSubConfig("tracing") != nil {
+		tracingMiddleware, _ := newTracingMiddleware(conf.GetSubConfig("tracing"))
+		middlewares = append([]*Middleware{tracingMiddleware}, middlewares...)
+	}
+
+	gatewayOptions := []runtime.ServeMuxOption{
 		runtime.WithUnescapingMode(runtime.UnescapingModeAllExceptReserved),
-	)
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{}
+	streamInterceptors := []grpc.StreamServerInterceptor{}
+	httpMiddlewares := []func(http.Handler) http.Handler{}
+	for _, m := range middlewares {
+		if m.Unary != nil {
+			unaryInterceptors = append(unaryInterceptors, m.Unary)
+		}
+		if m.Stream != nil {
+			streamInterceptors = append(streamInterceptors, m.Stream)
+		}
+		if m.HTTP != nil {
+			httpMiddlewares = append(httpMiddlewares, m.HTTP)
+		}
+		gatewayOptions = append(gatewayOptions, m.GatewayOptions...)
+	}
+	// interceptors from config run before the ones passed in by opts
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}, opts...)
+
+	serverMux := runtime.NewServeMux(gatewayOptions...)
 
 	gatewayPathPrefix := "/"
 	if httpPort > 0 {
@@ -92,14 +166,25 @@ func NewServerWithConfig(conf *config.Config, opts ...grpc.ServerOption) *grpcSe
 
 	initComponents(conf)
 
+	if serverTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS)))
+		logging.Infow("tls enabled for grpc", "client auth", serverTLS.ClientAuth.String())
+	}
+
 	srv := grpc.NewServer(
 		opts...,
 	)
 
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(srv, healthServer)
+
+	httpMux := http.NewServeMux()
+
 	return &grpcServer{
 		conf:             conf,
 		server:           srv,
-		httpMux:          http.NewServeMux(),
+		httpServer:       &http.Server{Addr: fmt.Sprintf(":%d", httpPort), Handler: httpMux, TLSConfig: httpTLS},
+		httpMux:          httpMux,
 		gatewayMux:       serverMux,
 		gatewayRoutePath: gatewayPathPrefix,
 		ctx:              ctx,
@@ -107,6 +192,10 @@ func NewServerWithConfig(conf *config.Config, opts ...grpc.ServerOption) *grpcSe
 		port:             port,
 		httpPort:         httpPort,
 		clientConn:       &gatewayClient{connection: conn},
+		httpMiddlewares:  httpMiddlewares,
+		healthServer:     healthServer,
+		shutdownTimeout:  time.Duration(conf.GetInt("shutdown.timeout", 30)) * time.Second,
+		stopped:          make(chan struct{}),
 	}
 }
 
@@ -182,10 +271,22 @@ func (g *grpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // Start serving grpc and http server
 func (g *grpcServer) Serve() error {
 	reflection.Register(g.server)
+	g.serveHealth()
 
-	g.httpMux.Handle(g.gatewayRoutePath, g)
+	// the first middleware defined in config is the outermost one
+	var gatewayHandler http.Handler = g
+	for i := len(g.httpMiddlewares) - 1; i >= 0; i-- {
+		gatewayHandler = g.httpMiddlewares[i](gatewayHandler)
+	}
+	g.httpMux.Handle(g.gatewayRoutePath, gatewayHandler)
 	logging.Infof("grpc-gateway path: %s", g.gatewayRoutePath)
 
+	if g.conf.GetSubConfig("metrics") != nil && g.httpPort > 0 {
+		metricsPath := g.conf.GetString("metrics.path", "/metrics")
+		g.httpMux.Handle(metricsPath, metrics.Handler())
+		logging.Infof("metrics path: %s", metricsPath)
+	}
+
 	if g.conf.GetString("http.static.path", "") != "" {
 		staticPath := g.conf.GetString("http.static.path")
 		if !strings.HasSuffix(staticPath, "/") {
@@ -219,13 +320,117 @@ func (g *grpcServer) Serve() error {
 
 	if g.httpPort > 0 {
 		go func() {
-			if err := http.ListenAndServe(fmt.Sprintf(":%d", g.httpPort), g.httpMux); err != nil {
+			var err error
+			if g.httpServer.TLSConfig != nil {
+				// certificates are already loaded in TLSConfig
+				err = g.httpServer.ListenAndServeTLS("", "")
+			} else {
+				err = g.httpServer.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
 				logging.Fatalf(err.Error())
 			}
 		}()
 	}
 
-	return g.server.Serve(lis)
+	if g.conf.GetBool("shutdown.signal", false) {
+		go g.handleSignals()
+	}
+
+	err = g.server.Serve(lis)
+	if g.isStopping() {
+		// grpc returns once it's stopped, wait for the rest of shutdown, e.g. flushing traces
+		<-g.stopped
+	}
+	return err
+}
+
+// Shutdown stops the http gateway and drains in-flight grpc calls.
+// If ctx is done before draining completes, the grpc server is stopped immediately.
+// It's safe to call Shutdown more than once, only the first call takes effect,
+// and the others wait for it to complete until their ctx is done.
+func (g *grpcServer) Shutdown(ctx context.Context) error {
+	var err error
+
+	g.stopOnce.Do(func() {
+		defer close(g.stopped)
+		logging.Infof("shutting down grpc server")
+		// report not serving first, so no more traffic is routed here
+		atomic.StoreInt32(&g.stopping, 1)
+		g.healthServer.Shutdown()
+
+		if g.httpPort > 0 {
+			if httpErr := g.httpServer.Shutdown(ctx); httpErr != nil {
+				err = logging.Errorf("failed to shutdown http server: %s", httpErr.Error())
+			}
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			g.server.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			logging.Warnf("grpc server not drained in time, force stopping")
+			g.server.Stop()
+			err = ctx.Err()
+		}
+
+		g.cancelFunc()
+		if closeErr := g.clientConn.Close(); closeErr != nil {
+			logging.Errorf("failed to close gateway connection: %s", closeErr.Error())
+		}
+		flushCtx := ctx
+		if ctx.Err() != nil {
+			// spans of the force stopped calls are still exported
+			var cancel context.CancelFunc
+			flushCtx, cancel = context.WithTimeout(context.Background(), traceFlushTimeout)
+			defer cancel()
+		}
+		if traceErr := tracing.Shutdown(flushCtx); traceErr != nil {
+			logging.Errorf("failed to flush traces: %s", traceErr.Error())
+		}
+
+		logging.Infof("grpc server stopped")
+	})
+
+	select {
+	case <-g.stopped:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// GracefulStop shuts down the server, using shutdown.timeout in config as the drain timeout
+func (g *grpcServer) GracefulStop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.shutdownTimeout)
+	defer cancel()
+
+	return g.Shutdown(ctx)
+}
+
+func (g *grpcServer) isStopping() bool {
+	return atomic.LoadInt32(&g.stopping) == 1
+}
+
+// wait for SIGINT/SIGTERM and stop the server gracefully
+func (g *grpcServer) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case sig := <-signals:
+		logging.Infow("signal received", "signal", sig.String())
+		g.GracefulStop()
+	case <-g.ctx.Done():
+	}
 }
 
 // GetGatewayInfo 返回Http网关相关信息
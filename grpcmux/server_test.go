@@ -0,0 +1,89 @@
+package grpcmux
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	pb "github.com/skema-dev/skema-go/sample/api/skema/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// slowServer replies after a while, so the call is in flight when shutting down
+type slowServer struct {
+	pb.UnimplementedTestServer
+	started chan struct{}
+}
+
+func (s *slowServer) Helloworld(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+	close(s.started)
+	time.Sleep(300 * time.Millisecond)
+	return &pb.HelloReply{Msg: req.Msg}, nil
+}
+
+func freePort(t *testing.T) int {
+	lis, err := net.Listen("tcp", ":0")
+	assert.Nil(t, err)
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestShutdown(t *testing.T) {
+	port, httpPort := freePort(t), freePort(t)
+	srv := NewServerWithConfig(config.NewConfigWithString(fmt.Sprintf(`
+port: %d
+http:
+  port: %d
+`, port, httpPort)))
+	started := make(chan struct{})
+	pb.RegisterTestServer(srv, &slowServer{started: started})
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve() }()
+
+	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	replied := make(chan *pb.HelloReply, 1)
+	go func() {
+		reply, err := pb.NewTestClient(conn).Helloworld(context.Background(), &pb.HelloRequest{Msg: "hello"}, grpc.WaitForReady(true))
+		assert.Nil(t, err)
+		replied <- reply
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, srv.Shutdown(ctx))
+
+	// the in-flight call is drained, and Serve returns after the whole shutdown
+	select {
+	case reply := <-replied:
+		assert.Equal(t, "hello", reply.GetMsg())
+	case <-time.After(time.Second):
+		t.Fatal("in-flight call not completed")
+	}
+	select {
+	case err := <-served:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve not returned")
+	}
+
+	for _, p := range []int{port, httpPort} {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", p))
+		assert.Nil(t, err)
+		if lis != nil {
+			lis.Close()
+		}
+	}
+
+	// only the first call takes effect
+	assert.Nil(t, srv.Shutdown(ctx))
+}
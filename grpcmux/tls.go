@@ -0,0 +1,152 @@
+package grpcmux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/skema-dev/skema-go/config"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLS is enabled when the cert is defined, e.g.
+//
+// tls:
+//   cert: ./certs/server.crt    # server certificate
+//   key: ./certs/server.key     # server private key
+//   ca: ./certs/ca.crt          # CA of the server cert, used by the internal gateway connection
+//   client_ca: ./certs/ca.crt   # CA to verify client certificates
+//   require_client_cert: true   # mTLS. the server cert is also used as client cert by the gateway
+//   server_name: localhost      # server name used by the internal gateway connection
+// http:
+//   tls:                        # optional, same as above with cert required. the top level tls is used if not defined
+// client:
+//   tls:
+//     ca: ./certs/ca.crt
+//     cert: ./certs/client.crt  # optional, client certificate for mTLS
+//     key: ./certs/client.key
+//     server_name: myservice
+
+// load server side tls config. return nil if tls is not enabled
+func loadServerTLSConfig(conf *config.Config) (*tls.Config, error) {
+	if conf == nil || conf.GetString("cert") == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.GetString("cert"), conf.GetString("key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA := conf.GetString("client_ca"); clientCA != "" {
+		pool, err := loadCertPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if conf.GetBool("require_client_cert", false) {
+		if tlsConfig.ClientCAs == nil {
+			return nil, errors.New("client_ca must be defined when require_client_cert is enabled")
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// load tls config of the http gateway from http.tls, or use the server tls config if it's not defined
+func loadHTTPTLSConfig(conf *config.Config, serverTLS *tls.Config) (*tls.Config, error) {
+	httpConf := conf.GetSubConfig("http.tls")
+	if httpConf == nil {
+		return serverTLS, nil
+	}
+	if httpConf.GetString("cert") == "" {
+		return nil, errors.New("cert must be defined in http.tls")
+	}
+	return loadServerTLSConfig(httpConf)
+}
+
+// load client side tls config. return nil if tls is not enabled
+func loadClientTLSConfig(conf *config.Config) (*tls.Config, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: conf.GetString("server_name"),
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if ca := conf.GetString("ca"); ca != "" {
+		pool, err := loadCertPool(ca)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile := conf.GetString("cert"); certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, conf.GetString("key"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// load tls config for the gateway connecting to the local grpc port, based on the server tls config
+func loadLoopbackTLSConfig(conf *config.Config) (*tls.Config, error) {
+	if conf == nil || conf.GetString("cert") == "" {
+		return nil, nil
+	}
+
+	tlsConfig, err := loadClientTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = "localhost"
+	}
+	if tlsConfig.RootCAs == nil && conf.GetString("client_ca") != "" {
+		if tlsConfig.RootCAs, err = loadCertPool(conf.GetString("client_ca")); err != nil {
+			return nil, err
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// transport credentials for client connections, insecure if tls is not enabled
+func transportCredentials(tlsConfig *tls.Config) credentials.TransportCredentials {
+	if tlsConfig == nil {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(tlsConfig)
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA %s: %w", caFile, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no valid certificate found in CA %s", caFile)
+	}
+
+	return pool, nil
+}
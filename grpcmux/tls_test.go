@@ -0,0 +1,202 @@
+package grpcmux
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCerts struct {
+	ca   string
+	cert string
+	key  string
+	// not a pem file
+	bad string
+}
+
+// generate a CA and a localhost certificate signed by it in dir
+func generateCerts(t *testing.T, dir string) testCerts {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.Nil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certs := testCerts{
+		ca:   filepath.Join(dir, "ca.crt"),
+		cert: filepath.Join(dir, "server.crt"),
+		key:  filepath.Join(dir, "server.key"),
+		bad:  filepath.Join(dir, "bad.crt"),
+	}
+	assert.Nil(t, os.WriteFile(certs.ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+	assert.Nil(t, os.WriteFile(certs.cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+	assert.Nil(t, os.WriteFile(certs.key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	assert.Nil(t, os.WriteFile(certs.bad, []byte("not a certificate"), 0600))
+	return certs
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	certs := generateCerts(t, t.TempDir())
+
+	tests := []struct {
+		name       string
+		conf       string
+		enabled    bool
+		clientAuth tls.ClientAuthType
+		hasError   bool
+	}{
+		{name: "disabled", conf: `ca: ` + certs.ca},
+		{name: "server cert", conf: fmt.Sprintf("cert: %s\nkey: %s", certs.cert, certs.key), enabled: true},
+		{name: "missing key", conf: fmt.Sprintf("cert: %s", certs.cert), hasError: true},
+		{name: "bad cert", conf: fmt.Sprintf("cert: %s\nkey: %s", certs.bad, certs.key), hasError: true},
+		{
+			name:       "optional client cert",
+			conf:       fmt.Sprintf("cert: %s\nkey: %s\nclient_ca: %s", certs.cert, certs.key, certs.ca),
+			enabled:    true,
+			clientAuth: tls.VerifyClientCertIfGiven,
+		},
+		{
+			name:       "mtls",
+			conf:       fmt.Sprintf("cert: %s\nkey: %s\nclient_ca: %s\nrequire_client_cert: true", certs.cert, certs.key, certs.ca),
+			enabled:    true,
+			clientAuth: tls.RequireAndVerifyClientCert,
+		},
+		{name: "bad client ca", conf: fmt.Sprintf("cert: %s\nkey: %s\nclient_ca: %s", certs.cert, certs.key, certs.bad), hasError: true},
+		{name: "missing client ca", conf: fmt.Sprintf("cert: %s\nkey: %s\nclient_ca: %s.missing", certs.cert, certs.key, certs.ca), hasError: true},
+		{
+			name:     "require client cert without client ca",
+			conf:     fmt.Sprintf("cert: %s\nkey: %s\nrequire_client_cert: true", certs.cert, certs.key),
+			hasError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := loadServerTLSConfig(config.NewConfigWithString(tt.conf))
+			if tt.hasError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			if !tt.enabled {
+				assert.Nil(t, tlsConfig)
+				return
+			}
+			assert.Equal(t, 1, len(tlsConfig.Certificates))
+			assert.Equal(t, tt.clientAuth, tlsConfig.ClientAuth)
+			assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+		})
+	}
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	certs := generateCerts(t, t.TempDir())
+
+	tests := []struct {
+		name     string
+		conf     string
+		hasCA    bool
+		hasCert  bool
+		hasError bool
+	}{
+		{name: "system roots", conf: "server_name: myservice"},
+		{name: "ca", conf: "ca: " + certs.ca, hasCA: true},
+		{name: "mtls", conf: fmt.Sprintf("ca: %s\ncert: %s\nkey: %s", certs.ca, certs.cert, certs.key), hasCA: true, hasCert: true},
+		{name: "bad ca", conf: "ca: " + certs.bad, hasError: true},
+		{name: "missing key", conf: fmt.Sprintf("ca: %s\ncert: %s", certs.ca, certs.cert), hasError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := loadClientTLSConfig(config.NewConfigWithString(tt.conf))
+			if tt.hasError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.hasCA, tlsConfig.RootCAs != nil)
+			assert.Equal(t, tt.hasCert, len(tlsConfig.Certificates) == 1)
+		})
+	}
+
+	tlsConfig, err := loadClientTLSConfig(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+}
+
+func TestLoopbackTLSConfig(t *testing.T) {
+	certs := generateCerts(t, t.TempDir())
+
+	tlsConfig, err := loadLoopbackTLSConfig(config.NewConfigWithString("ca: " + certs.ca))
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+
+	// the client ca verifies the server cert, and the server cert is the client cert for mtls
+	tlsConfig, err = loadLoopbackTLSConfig(config.NewConfigWithString(
+		fmt.Sprintf("cert: %s\nkey: %s\nclient_ca: %s\nrequire_client_cert: true", certs.cert, certs.key, certs.ca)))
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost", tlsConfig.ServerName)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Equal(t, 1, len(tlsConfig.Certificates))
+
+	_, err = loadLoopbackTLSConfig(config.NewConfigWithString(
+		fmt.Sprintf("cert: %s\nkey: %s\nclient_ca: %s", certs.cert, certs.key, certs.bad)))
+	assert.NotNil(t, err)
+}
+
+func TestHTTPTLSConfig(t *testing.T) {
+	certs := generateCerts(t, t.TempDir())
+	serverTLS := &tls.Config{}
+
+	tlsConfig, err := loadHTTPTLSConfig(config.NewConfigWithString("http:\n  port: 8080"), serverTLS)
+	assert.Nil(t, err)
+	assert.Equal(t, serverTLS, tlsConfig)
+
+	tlsConfig, err = loadHTTPTLSConfig(config.NewConfigWithString(
+		fmt.Sprintf("http:\n  tls:\n    cert: %s\n    key: %s", certs.cert, certs.key)), serverTLS)
+	assert.Nil(t, err)
+	assert.NotEqual(t, serverTLS, tlsConfig)
+	assert.Equal(t, 1, len(tlsConfig.Certificates))
+
+	// not served in plain text when the block has no cert
+	_, err = loadHTTPTLSConfig(config.NewConfigWithString(
+		fmt.Sprintf("http:\n  tls:\n    client_ca: %s", certs.ca)), serverTLS)
+	assert.NotNil(t, err)
+}
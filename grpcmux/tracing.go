@@ -0,0 +1,47 @@
+package grpcmux
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/skema-dev/skema-go/config"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// tracing middleware is enabled automatically as the outermost one when tracing is defined in config.
+// The gateway span is propagated to the grpc handler through the internal client connection.
+func newTracingMiddleware(conf *config.Config) (*Middleware, error) {
+	return &Middleware{
+		Unary:  otelgrpc.UnaryServerInterceptor(),
+		Stream: otelgrpc.StreamServerInterceptor(),
+		HTTP: func(next http.Handler) http.Handler {
+			return otelhttp.NewHandler(next, "gateway",
+				otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+					return r.Method + " " + r.URL.Path
+				}),
+			)
+		},
+		GatewayOptions: []runtime.ServeMuxOption{
+			// rename the span with the route pattern, so it won't explode with raw url paths
+			runtime.WithMetadata(func(ctx context.Context, r *http.Request) metadata.MD {
+				if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+					trace.SpanFromContext(ctx).SetName(r.Method + " " + pattern)
+				}
+				return nil
+			}),
+		},
+	}, nil
+}
+
+// client interceptors injecting the trace context into outgoing metadata
+func tracingDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+	}
+}
@@ -0,0 +1,178 @@
+package grpcmux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/logging"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// interfaces of messages and errors generated by protoc-gen-validate
+type validator interface {
+	Validate() error
+}
+
+type validatorAll interface {
+	ValidateAll() error
+}
+
+type validationFieldError interface {
+	Field() string
+	Reason() string
+	Cause() error
+}
+
+type validationMultiError interface {
+	AllErrors() []error
+}
+
+// ErrorBody is the json body returned by the http gateway for failed requests
+type ErrorBody struct {
+	Code       int              `json:"code"`
+	Status     string           `json:"status"`
+	Message    string           `json:"message"`
+	Violations []FieldViolation `json:"violations,omitempty"`
+}
+
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// server stream validating every received message
+type validatingServerStream struct {
+	grpc.ServerStream
+	all bool
+}
+
+func (v *validatingServerStream) RecvMsg(m interface{}) error {
+	if err := v.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return validateMessage(m, v.all)
+}
+
+// validate request with the methods generated by protoc-gen-validate.
+// return codes.InvalidArgument with errdetails.BadRequest when validation failed.
+// with all=true, ValidateAll() is used if available to report all violations.
+func validateMessage(m interface{}, all bool) error {
+	var err error
+	if v, ok := m.(validatorAll); ok && all {
+		err = v.ValidateAll()
+	} else if v, ok := m.(validator); ok {
+		err = v.Validate()
+	}
+	if err == nil {
+		return nil
+	}
+
+	badRequest := &errdetails.BadRequest{
+		FieldViolations: toFieldViolations("", err),
+	}
+	st, detailErr := status.New(codes.InvalidArgument, err.Error()).WithDetails(badRequest)
+	if detailErr != nil {
+		logging.Errorf("failed attaching validation details: %s", detailErr.Error())
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return st.Err()
+}
+
+// flatten validation errors, nested message fields are joined with "."
+func toFieldViolations(prefix string, err error) []*errdetails.BadRequest_FieldViolation {
+	result := []*errdetails.BadRequest_FieldViolation{}
+
+	switch e := err.(type) {
+	case validationMultiError:
+		for _, item := range e.AllErrors() {
+			result = append(result, toFieldViolations(prefix, item)...)
+		}
+	case validationFieldError:
+		field := e.Field()
+		if prefix != "" {
+			field = prefix + "." + field
+		}
+		// embedded message failed validation, report the nested fields instead
+		if cause := e.Cause(); cause != nil {
+			if nested := toFieldViolations(field, cause); len(nested) > 0 {
+				return append(result, nested...)
+			}
+		}
+		result = append(result, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: e.Reason(),
+		})
+	}
+
+	return result
+}
+
+// write grpc errors as ErrorBody, including the field violations if any
+func gatewayErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler,
+	w http.ResponseWriter, r *http.Request, err error) {
+	st := status.Convert(err)
+
+	body := ErrorBody{
+		Code:    int(st.Code()),
+		Status:  st.Code().String(),
+		Message: st.Message(),
+	}
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range badRequest.GetFieldViolations() {
+				body.Violations = append(body.Violations, FieldViolation{
+					Field:       v.GetField(),
+					Description: v.GetDescription(),
+				})
+			}
+		}
+	}
+
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		for k, values := range md.HeaderMD {
+			for _, v := range values {
+				w.Header().Add(runtime.MetadataHeaderPrefix+k, v)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(runtime.HTTPStatusFromCode(st.Code()))
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logging.Errorf("failed writing error response: %s", err.Error())
+	}
+}
+
+// middlewares:
+//   - validation:
+//       all: true   # report all violations with ValidateAll(), otherwise only the first one
+func newValidationMiddleware(conf *config.Config) (*Middleware, error) {
+	all := true
+	if conf != nil {
+		all = conf.GetBool("all", true)
+	}
+
+	return &Middleware{
+		Unary: func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			if err := validateMessage(req, all); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		},
+		Stream: func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+			return handler(srv, &validatingServerStream{ServerStream: ss, all: all})
+		},
+		GatewayOptions: []runtime.ServeMuxOption{
+			runtime.WithErrorHandler(gatewayErrorHandler),
+		},
+	}, nil
+}
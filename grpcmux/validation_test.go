@@ -0,0 +1,28 @@
+package grpcmux
+
+import (
+	"testing"
+
+	pb "github.com/skema-dev/skema-go/sample/api/skema/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateMessage(t *testing.T) {
+	assert.Nil(t, validateMessage(&pb.HelloRequest{Msg: "hello"}, true))
+	assert.Nil(t, validateMessage("not a proto message", true))
+
+	err := validateMessage(&pb.HelloRequest{Msg: "a"}, true)
+	assert.NotNil(t, err)
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, 1, len(st.Details()))
+
+	badRequest := st.Details()[0].(*errdetails.BadRequest)
+	assert.Equal(t, 1, len(badRequest.GetFieldViolations()))
+	assert.Equal(t, "Msg", badRequest.GetFieldViolations()[0].GetField())
+	assert.Equal(t, "value length must be at least 3 runes", badRequest.GetFieldViolations()[0].GetDescription())
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/skema-dev/skema-go/logging"
+)
+
+// Checker returns error if the dependency is not ready to serve
+type Checker func(ctx context.Context) error
+
+var (
+	mux      sync.RWMutex
+	checkers = map[string]Checker{}
+)
+
+// Register a readiness checker by name, e.g. "database:db1".
+// Existing checker with the same name will be overwritten.
+func Register(name string, checker Checker) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if _, ok := checkers[name]; ok {
+		logging.Warnw("health checker already exists and will be overwritten.", "name", name)
+	}
+	checkers[name] = checker
+	logging.Debugw("health checker registered", "name", name)
+}
+
+func Unregister(name string) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	delete(checkers, name)
+}
+
+// Names of all registered checkers, sorted
+func Names() []string {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	result := make([]string, 0, len(checkers))
+	for name := range checkers {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// CheckAll runs all registered checkers concurrently and returns the result by name.
+// The error is nil for checkers passed.
+func CheckAll(ctx context.Context) map[string]error {
+	mux.RLock()
+	current := make(map[string]Checker, len(checkers))
+	for k, v := range checkers {
+		current[k] = v
+	}
+	mux.RUnlock()
+
+	type checkResult struct {
+		name string
+		err  error
+	}
+	ch := make(chan checkResult, len(current))
+	for name, checker := range current {
+		go func(name string, checker Checker) {
+			ch <- checkResult{name: name, err: checker(ctx)}
+		}(name, checker)
+	}
+
+	result := make(map[string]error, len(current))
+	for range current {
+		r := <-ch
+		if r.err != nil {
+			logging.Warnw("health check failed", "name", r.name, "error", r.err.Error())
+		}
+		result[r.name] = r.err
+	}
+
+	return result
+}
+
+// Ready returns true if all registered checkers passed
+func Ready(ctx context.Context) bool {
+	for _, err := range CheckAll(ctx) {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,29 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/skema-dev/skema-go/health"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAll(t *testing.T) {
+	health.Register("test:ok", func(ctx context.Context) error { return nil })
+	assert.True(t, health.Ready(context.Background()))
+
+	health.Register("test:failed", func(ctx context.Context) error { return errors.New("not ready") })
+	assert.Equal(t, []string{"test:failed", "test:ok"}, health.Names())
+	assert.False(t, health.Ready(context.Background()))
+
+	result := health.CheckAll(context.Background())
+	assert.Equal(t, 2, len(result))
+	assert.Nil(t, result["test:ok"])
+	assert.NotNil(t, result["test:failed"])
+
+	health.Unregister("test:failed")
+	assert.True(t, health.Ready(context.Background()))
+	health.Unregister("test:ok")
+	assert.Equal(t, 0, len(health.Names()))
+}
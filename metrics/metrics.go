@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "skema"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "Total number of grpc requests by method and status code.",
+	}, []string{"method", "code"})
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Latency of grpc requests by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of http gateway requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http gateway requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	daoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dao_operation_duration_seconds",
+		Help:      "Latency of DAO operations by database, table and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"database", "table", "operation"})
+	daoErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dao_operation_errors_total",
+		Help:      "Total number of failed DAO operations by database, table and operation.",
+	}, []string{"database", "table", "operation"})
+
+	redisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Latency of redis commands by command and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command", "result"})
+
+	elasticDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "elastic_request_duration_seconds",
+		Help:      "Latency of elasticsearch requests by operation and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+	cqrsFallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cqrs_fallback_total",
+		Help:      "Total number of queries falling back to the database when elasticsearch search failed.",
+	}, []string{"database", "table"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		rpcRequests, rpcDuration,
+		httpRequests, httpDuration,
+		daoDuration, daoErrors,
+		redisDuration,
+		elasticDuration, cqrsFallbacks,
+	)
+}
+
+// Registry for all skema-go metrics. Register your own collectors here to expose them on the same path
+func Registry() *prometheus.Registry {
+	return registry
+}
+
+// Handler serves metrics in prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+func ObserveRPC(method string, code string, start time.Time) {
+	rpcRequests.WithLabelValues(method, code).Inc()
+	rpcDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
+
+func ObserveHTTP(method string, route string, status string, start time.Time) {
+	httpRequests.WithLabelValues(method, route, status).Inc()
+	httpDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+}
+
+func ObserveDAO(database string, table string, operation string, start time.Time, err error) {
+	daoDuration.WithLabelValues(database, table, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		daoErrors.WithLabelValues(database, table, operation).Inc()
+	}
+}
+
+func ObserveRedis(command string, start time.Time, err error) {
+	redisDuration.WithLabelValues(command, result(err)).Observe(time.Since(start).Seconds())
+}
+
+func ObserveElastic(operation string, start time.Time, err error) {
+	elasticDuration.WithLabelValues(operation, result(err)).Observe(time.Since(start).Seconds())
+}
+
+func IncCQRSFallback(database string, table string) {
+	cqrsFallbacks.WithLabelValues(database, table).Inc()
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveDAO(t *testing.T) {
+	ObserveDAO("sqlite", "user", "create", time.Now(), nil)
+	ObserveDAO("sqlite", "user", "create", time.Now(), errors.New("failed"))
+
+	assert.Equal(t, 0.0, testutil.ToFloat64(daoErrors.WithLabelValues("sqlite", "user", "query")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(daoErrors.WithLabelValues("sqlite", "user", "create")))
+
+	IncCQRSFallback("sqlite", "user")
+	assert.Equal(t, 1.0, testutil.ToFloat64(cqrsFallbacks.WithLabelValues("sqlite", "user")))
+}
+
+func TestHandler(t *testing.T) {
+	ObserveRPC("/test.Test/Hello", "OK", time.Now())
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `skema_grpc_requests_total{code="OK",method="/test.Test/Hello"} 1`))
+}
@@ -29,12 +29,17 @@ func NewRedisClient(config *config.Config) (*RedisClient, error) {
 		return nil, errors.New(msg)
 	}
 
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+	// add hook before copying, commands are processed by the original client
+	rdb.AddHook(metricsHook{})
+	rdb.AddHook(tracingHook{})
+
 	client := RedisClient{
-		Client: *redis.NewClient(&redis.Options{
-			Addr:     addr,
-			Password: password,
-			DB:       db,
-		}),
+		Client:  *rdb,
 		ctx:     context.Background(),
 		pubsubs: map[string]*redis.PubSub{},
 	}
@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/skema-dev/skema-go/metrics"
+	"github.com/skema-dev/skema-go/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type hookStartKey struct{}
+
+// metricsHook records the latency of every redis command
+type metricsHook struct{}
+
+func (metricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, hookStartKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(hookStartKey{}).(time.Time); ok {
+		metrics.ObserveRedis(cmd.Name(), start, commandError(cmd))
+	}
+	return nil
+}
+
+func (metricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, hookStartKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(hookStartKey{}).(time.Time); ok {
+		var err error
+		for _, cmd := range cmds {
+			if err = commandError(cmd); err != nil {
+				break
+			}
+		}
+		metrics.ObserveRedis("pipeline", start, err)
+	}
+	return nil
+}
+
+// tracingHook creates a span for every redis command, as a child of the span in the command context
+type tracingHook struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = tracing.Start(ctx, "redis."+cmd.Name(),
+		semconv.DBSystemRedis,
+		semconv.DBOperationKey.String(cmd.Name()),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	tracing.End(trace.SpanFromContext(ctx), commandError(cmd))
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, _ = tracing.Start(ctx, "redis.pipeline",
+		semconv.DBSystemRedis,
+		semconv.DBOperationKey.String("pipeline"),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = commandError(cmd); err != nil {
+			break
+		}
+	}
+	tracing.End(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+// redis.Nil means key not found, which is not a failure
+func commandError(cmd redis.Cmder) error {
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		return err
+	}
+	return nil
+}
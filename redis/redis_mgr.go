@@ -1,7 +1,10 @@
 package redis
 
 import (
+	"context"
+
 	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/health"
 	"github.com/skema-dev/skema-go/logging"
 )
 
@@ -62,6 +65,9 @@ func (d *RedisManager) AddRedisClientsInPool(conf *config.Config, key string) {
 	}
 
 	d.redisPool[key] = rdb
+	health.Register("redis:"+key, func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	})
 }
 
 // GetRedis get a redis client
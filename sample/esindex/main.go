@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/data"
+	"github.com/skema-dev/skema-go/logging"
+)
+
+type TestData struct {
+	data.Model
+	Name string
+	Age  int
+}
+
+func (TestData) TableName() string {
+	return "test_data"
+}
+
+// go run . check -db db1
+// go run . -config config.yaml reindex -db db1 -tables test_data
+func main() {
+	configFile := flag.String("config", "", "config file, the sample config with a local elastic by default")
+	flag.Parse()
+
+	conf := config.NewConfigWithString(`
+databases:
+  db1:
+    type: sqlite
+    filepath: default.db
+    automigrate: true
+    cqrs:
+       type: elastic
+       name: elastic-search
+
+elastic-search:
+    version: v7
+    addresses:
+        - http://localhost:9200
+`)
+	if *configFile != "" {
+		conf = config.NewConfigWithFile(*configFile)
+	}
+	data.InitWithConfig(conf, "databases")
+	data.Manager().GetDAO(&TestData{})
+
+	if err := data.Manager().RunIndexCommand(context.Background(), flag.Args(), os.Stdout); err != nil {
+		logging.Fatalf(err.Error())
+	}
+}
@@ -0,0 +1,175 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/skema-dev/skema-go/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/skema-dev/skema-go"
+
+var (
+	mux            sync.Mutex
+	provider       *sdktrace.TracerProvider
+	memoryExporter *tracetest.InMemoryExporter
+)
+
+// Init sets the global tracer provider and propagator from config:
+//
+//	tracing:
+//	  service: my-service   # service.name of all spans
+//	  exporter: otlp        # otlp | stdout | memory | none
+//	  endpoint: localhost:4317
+//	  insecure: true        # plain text connection to the otlp collector
+//	  ratio: 0.1            # sampling ratio for new traces, 1 by default
+//
+// Sampling decision of the incoming request is always respected.
+func Init(conf *config.Config) error {
+	if conf == nil {
+		return nil
+	}
+
+	serviceName := conf.GetString("service", "skema-service")
+	exporterType := conf.GetString("exporter", "stdout")
+	ratio := conf.GetFloat("ratio", 1)
+
+	var (
+		exporter sdktrace.SpanExporter
+		memory   *tracetest.InMemoryExporter
+		err      error
+	)
+	switch exporterType {
+	case "otlp":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(conf.GetString("endpoint", "localhost:4317"))}
+		if conf.GetBool("insecure", false) {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(context.Background(), opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "memory":
+		memory = tracetest.NewInMemoryExporter()
+		exporter = memory
+	case "none":
+	default:
+		return fmt.Errorf("tracing exporter %s is not supported", exporterType)
+	}
+	if err != nil {
+		return err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		)),
+	}
+	if memory != nil {
+		// export synchronously, so spans are visible as soon as they end
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	} else if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	mux.Lock()
+	previous := provider
+	provider = sdktrace.NewTracerProvider(opts...)
+	memoryExporter = memory
+	otel.SetTracerProvider(provider)
+	mux.Unlock()
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if previous != nil {
+		previous.Shutdown(context.Background())
+	}
+
+	logging.Infow("tracing initialized", "service", serviceName, "exporter", exporterType, "ratio", ratio)
+	return nil
+}
+
+// Enabled returns true if Init has been called with a tracing config
+func Enabled() bool {
+	mux.Lock()
+	defer mux.Unlock()
+
+	return provider != nil
+}
+
+// Shutdown flushes pending spans and stops the exporter. The global tracer provider is reset to noop,
+// so spans started afterwards are not sent to the stopped exporter.
+func Shutdown(ctx context.Context) error {
+	mux.Lock()
+	current := provider
+	provider = nil
+	memoryExporter = nil
+	if current != nil {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}
+	mux.Unlock()
+
+	if current == nil {
+		return nil
+	}
+	return current.Shutdown(ctx)
+}
+
+// MemoryExporter returns the exporter keeping all ended spans in memory when exporter is "memory", otherwise nil.
+func MemoryExporter() *tracetest.InMemoryExporter {
+	mux.Lock()
+	defer mux.Unlock()
+
+	return memoryExporter
+}
+
+// Tracer used by all skema-go instrumentations
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start a client span of a call to a remote service, e.g. a sql statement or an elastic request,
+// as a child of the span in ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return start(ctx, name, trace.SpanKindClient, attrs...)
+}
+
+// StartInternal starts an internal span of an operation in the service, e.g. a dao method,
+// as a child of the span in ctx, if any
+func StartInternal(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return start(ctx, name, trace.SpanKindInternal, attrs...)
+}
+
+func start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Tracer().Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// End the span, recording err if it's not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
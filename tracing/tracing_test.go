@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/skema-dev/skema-go/config"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInitWithMemoryExporter(t *testing.T) {
+	conf := config.NewConfigWithString(`
+service: test-service
+exporter: memory
+`)
+	assert.Nil(t, Init(conf))
+	defer Shutdown(context.Background())
+	assert.True(t, Enabled())
+
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child")
+	End(child, errors.New("failed"))
+	End(parent, nil)
+
+	spans := MemoryExporter().GetSpans()
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	serviceName, _ := spans[1].Resource.Set().Value(semconv.ServiceNameKey)
+	assert.Equal(t, "test-service", serviceName.AsString())
+}
+
+func TestShutdown(t *testing.T) {
+	assert.Nil(t, Init(config.NewConfigWithString(`exporter: memory`)))
+
+	_, span := StartInternal(context.Background(), "internal")
+	assert.True(t, span.IsRecording())
+	assert.Equal(t, trace.SpanKindInternal, span.(sdktrace.ReadOnlySpan).SpanKind())
+	End(span, nil)
+
+	assert.Nil(t, Shutdown(context.Background()))
+	assert.False(t, Enabled())
+	assert.Nil(t, MemoryExporter())
+	// the global provider is reset, not left with the stopped one
+	_, span = Start(context.Background(), "after shutdown")
+	assert.False(t, span.IsRecording())
+	assert.Nil(t, Shutdown(context.Background()))
+}
+
+func TestInitWithInvalidExporter(t *testing.T) {
+	conf := config.NewConfigWithString(`exporter: unknown`)
+	assert.NotNil(t, Init(conf))
+	assert.Nil(t, Init(nil))
+}
+
+func TestSamplingRatio(t *testing.T) {
+	conf := config.NewConfigWithString(`
+exporter: memory
+ratio: 0
+`)
+	assert.Nil(t, Init(conf))
+	defer Shutdown(context.Background())
+
+	_, span := Start(context.Background(), "dropped")
+	End(span, nil)
+	assert.Equal(t, 0, len(MemoryExporter().GetSpans()))
+}